@@ -61,7 +61,7 @@ func main() {
 		panic(fmt.Errorf("failed to init logger: %s", err.Error()))
 	}
 
-	server, err := server.New(cfg.ServerConfig, logger)
+	server, err := server.New(cfg.ServerConfig, cfg.AuthConfig, logger)
 	if err != nil {
 		logger.Fatal("failed to start server", zap.Error(err))
 	}
@@ -82,7 +82,8 @@ func main() {
 	logger.Info("server successfully started", zap.String("ip", cfg.Ip), zap.Uint16("port", cfg.Port))
 
 	<-ctx.Done()
-	server.Stop(ctx)
 	stop()
+	// ctx is already done, the drain timeout of the server bounds the shutdown.
+	server.Stop(context.Background())
 	wg.Wait()
 }
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	queryTokenName = "token"
+	bearerPrefix   = "Bearer "
+)
+
+var (
+	// ErrUnauthorized means the request has no valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNoCredentials means the request carries no credentials at all.
+	ErrNoCredentials = errors.New("no credentials")
+)
+
+type Role string
+
+const (
+	RolePublish   Role = "publish"
+	RoleSubscribe Role = "subscribe"
+	RoleBoth      Role = "both"
+)
+
+// Identity is an authenticated client together with its permissions.
+type Identity struct {
+	Name     string
+	Role     Role
+	Channels []string
+}
+
+// Allows reports whether the identity may join the channel as a publisher or a subscriber.
+func (id Identity) Allows(channel string, publisher bool) bool {
+	switch {
+	case id.Role == RoleBoth:
+	case publisher && id.Role != RolePublish:
+		return false
+	case !publisher && id.Role != RoleSubscribe:
+		return false
+	}
+
+	return MatchChannel(id.Channels, channel)
+}
+
+// MatchChannel reports whether the channel is in scope. An empty scope or "*"
+// allows any channel, a trailing "*" matches by prefix.
+func MatchChannel(scope []string, channel string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+
+	for _, pattern := range scope {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(channel, prefix) {
+				return true
+			}
+			continue
+		}
+		if pattern == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves the identity of an incoming request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Token extracts the credential from the Authorization bearer header or the token query parameter.
+func Token(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, bearerPrefix)
+		return strings.TrimSpace(token), ok && token != ""
+	}
+
+	if token := r.URL.Query().Get(queryTokenName); token != "" {
+		return token, true
+	}
+	return "", false
+}
+
+// Chain tries authenticators in order and returns the first identity found.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Identity, error) {
+	err := ErrNoCredentials
+	for _, authenticator := range c {
+		identity, authErr := authenticator.Authenticate(r)
+		if authErr == nil {
+			return identity, nil
+		}
+		if !errors.Is(authErr, ErrNoCredentials) {
+			err = authErr
+		}
+	}
+	return Identity{}, err
+}
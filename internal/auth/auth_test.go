@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllows(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		identity  Identity
+		channel   string
+		publisher bool
+		expected  bool
+	}{
+		{
+			name:      "publisher in any channel",
+			identity:  Identity{Role: RolePublish},
+			channel:   "kitchen",
+			publisher: true,
+			expected:  true,
+		},
+		{
+			name:      "publisher may not subscribe",
+			identity:  Identity{Role: RolePublish},
+			channel:   "kitchen",
+			publisher: false,
+			expected:  false,
+		},
+		{
+			name:      "subscriber may not publish",
+			identity:  Identity{Role: RoleSubscribe},
+			channel:   "kitchen",
+			publisher: true,
+			expected:  false,
+		},
+		{
+			name:      "both in scope",
+			identity:  Identity{Role: RoleBoth, Channels: []string{"hall", "kitchen"}},
+			channel:   "kitchen",
+			publisher: false,
+			expected:  true,
+		},
+		{
+			name:      "out of scope",
+			identity:  Identity{Role: RoleBoth, Channels: []string{"hall"}},
+			channel:   "kitchen",
+			publisher: true,
+			expected:  false,
+		},
+		{
+			name:      "prefix scope",
+			identity:  Identity{Role: RoleSubscribe, Channels: []string{"floor-1-*"}},
+			channel:   "floor-1-press",
+			publisher: false,
+			expected:  true,
+		},
+		{
+			name:      "wildcard scope",
+			identity:  Identity{Role: RoleSubscribe, Channels: []string{"*"}},
+			channel:   "anything",
+			publisher: false,
+			expected:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, tc.identity.Allows(tc.channel, tc.publisher))
+		})
+	}
+}
+
+func TestTokensAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	tokens, err := NewTokens([]config.TokenConfig{
+		{Name: "press", Token: "secret", Role: string(RolePublish), Channels: []string{"press"}},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		url         string
+		header      string
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:        "bearer header",
+			url:         "/connection/press",
+			header:      "Bearer secret",
+			expected:    "press",
+			expectedErr: nil,
+		},
+		{
+			name:        "query parameter",
+			url:         "/connection/press?token=secret",
+			header:      "",
+			expected:    "press",
+			expectedErr: nil,
+		},
+		{
+			name:        "wrong token",
+			url:         "/connection/press",
+			header:      "Bearer guess",
+			expected:    "",
+			expectedErr: ErrUnauthorized,
+		},
+		{
+			name:        "no token",
+			url:         "/connection/press",
+			header:      "",
+			expected:    "",
+			expectedErr: ErrNoCredentials,
+		},
+		{
+			name:        "not a bearer",
+			url:         "/connection/press",
+			header:      "Basic c2VjcmV0",
+			expected:    "",
+			expectedErr: ErrNoCredentials,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("GET", tc.url, nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+
+			identity, err := tokens.Authenticate(r)
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, tc.expected, identity.Name)
+		})
+	}
+}
+
+func TestNewTokensValidation(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewTokens([]config.TokenConfig{{Token: "secret", Role: "admin"}})
+	assert.Error(t, err)
+
+	_, err = NewTokens([]config.TokenConfig{{Token: "", Role: string(RoleBoth)}})
+	assert.Error(t, err)
+}
+
+func TestCertificatesAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	certificates, err := NewCertificates([]config.CertificateConfig{
+		{Name: "press-1", Role: string(RolePublish), Channels: []string{"press"}},
+		{Name: "spiffe://plant/hall", Role: string(RoleSubscribe)},
+	})
+	require.NoError(t, err)
+
+	hallURI, _ := url.Parse("spiffe://plant/hall")
+	tests := []struct {
+		name        string
+		cert        *x509.Certificate
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:        "common name",
+			cert:        &x509.Certificate{Subject: pkix.Name{CommonName: "press-1"}},
+			expected:    "press-1",
+			expectedErr: nil,
+		},
+		{
+			name:        "uri san",
+			cert:        &x509.Certificate{Subject: pkix.Name{CommonName: "device"}, URIs: []*url.URL{hallURI}},
+			expected:    "spiffe://plant/hall",
+			expectedErr: nil,
+		},
+		{
+			name:        "unknown certificate",
+			cert:        &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}, DNSNames: []string{"stranger.local"}},
+			expected:    "",
+			expectedErr: ErrUnauthorized,
+		},
+		{
+			name:        "no certificate",
+			cert:        nil,
+			expected:    "",
+			expectedErr: ErrNoCredentials,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("GET", "/connection/press", nil)
+			if tc.cert != nil {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.cert}}}
+			}
+
+			identity, err := certificates.Authenticate(r)
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, tc.expected, identity.Name)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/serg-pe/signals/internal/config"
+)
+
+// Certificates authenticates requests by the verified TLS client certificate.
+// An entry matches the certificate common name or any of its DNS, email or URI
+// subject alternative names.
+type Certificates struct {
+	identities map[string]Identity
+}
+
+func NewCertificates(cfg []config.CertificateConfig) (*Certificates, error) {
+	c := &Certificates{
+		identities: make(map[string]Identity, len(cfg)),
+	}
+
+	for i, certCfg := range cfg {
+		if certCfg.Name == "" {
+			return nil, fmt.Errorf("certificate %d: empty name", i)
+		}
+
+		role := Role(certCfg.Role)
+		switch role {
+		case RolePublish, RoleSubscribe, RoleBoth:
+		default:
+			return nil, fmt.Errorf("certificate %d: unknown role '%s', allowed %s, %s or %s", i, certCfg.Role, RolePublish, RoleSubscribe, RoleBoth)
+		}
+
+		c.identities[certCfg.Name] = Identity{
+			Name:     certCfg.Name,
+			Role:     role,
+			Channels: certCfg.Channels,
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Certificates) Authenticate(r *http.Request) (Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, name := range certificateNames(cert) {
+		if identity, ok := c.identities[name]; ok {
+			return identity, nil
+		}
+	}
+	return Identity{}, fmt.Errorf("%w: no identity for certificate '%s'", ErrUnauthorized, cert.Subject.CommonName)
+}
+
+func certificateNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, 1+len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs))
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
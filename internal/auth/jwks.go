@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+const (
+	algHS256 = "HS256"
+	algRS256 = "RS256"
+	algEdDSA = "EdDSA"
+
+	// minRSAKeyBits rejects RSA keys that are too short to be safe.
+	minRSAKeyBits = 2048
+)
+
+var (
+	errUnsupportedKey = errors.New("unsupported key")
+)
+
+// jwk is a single key of a JSON Web Key Set (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// oct
+	K string `json:"k"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// verificationKey is a parsed key bound to the only algorithm it may verify,
+// so a token can't pick a weaker algorithm for a key.
+type verificationKey struct {
+	kid string
+	alg string
+	key any
+}
+
+type keySet struct {
+	keys []verificationKey
+}
+
+func loadKeySet(path string) (*keySet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parse key set: %w", err)
+	}
+
+	set := &keySet{keys: make([]verificationKey, 0, len(doc.Keys))}
+	for i, key := range doc.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		parsed, err := parseKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %d (kid '%s'): %w", i, key.Kid, err)
+		}
+		set.keys = append(set.keys, parsed)
+	}
+	if len(set.keys) == 0 {
+		return nil, errors.New("key set has no signing keys")
+	}
+
+	return set, nil
+}
+
+func parseKey(key jwk) (verificationKey, error) {
+	result := verificationKey{kid: key.Kid}
+
+	switch key.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(key.K)
+		if err != nil || len(secret) == 0 {
+			return result, fmt.Errorf("invalid secret: %w", err)
+		}
+		result.alg, result.key = algHS256, secret
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return result, fmt.Errorf("invalid modulus: %w", err)
+		}
+		if n.BitLen() < minRSAKeyBits {
+			return result, fmt.Errorf("%w: rsa key of %d bits, at least %d required", errUnsupportedKey, n.BitLen(), minRSAKeyBits)
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil || !e.IsInt64() {
+			return result, fmt.Errorf("invalid exponent: %w", err)
+		}
+		result.alg, result.key = algRS256, &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return result, fmt.Errorf("%w: curve '%s'", errUnsupportedKey, key.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return result, fmt.Errorf("invalid public key: %w", err)
+		}
+		result.alg, result.key = algEdDSA, ed25519.PublicKey(x)
+	default:
+		return result, fmt.Errorf("%w: kty '%s'", errUnsupportedKey, key.Kty)
+	}
+
+	if key.Alg != "" && key.Alg != result.alg {
+		return result, fmt.Errorf("%w: alg '%s' for kty '%s'", errUnsupportedKey, key.Alg, key.Kty)
+	}
+	return result, nil
+}
+
+func decodeBigInt(raw string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// find returns the keys that may verify a token with the header kid and alg.
+func (s *keySet) find(kid, alg string) []verificationKey {
+	result := make([]verificationKey, 0, 1)
+	for _, key := range s.keys {
+		if key.alg != alg {
+			continue
+		}
+		if kid != "" && key.kid != kid {
+			continue
+		}
+		result = append(result, key)
+	}
+	return result
+}
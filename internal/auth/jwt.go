@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/serg-pe/signals/internal/config"
+	"go.uber.org/zap"
+)
+
+const (
+	jwtClockSkew = time.Second * 30
+
+	defaultRoleClaim      = "role"
+	defaultChannelsClaim  = "channels"
+	defaultReloadInterval = time.Second * 10
+)
+
+var (
+	errMalformedToken = errors.New("malformed token")
+	errInvalidToken   = errors.New("invalid token")
+)
+
+// JWT authenticates requests with JSON Web Tokens signed by keys from a JWKS file.
+type JWT struct {
+	logger *zap.Logger
+	cfg    config.JWTConfig
+
+	keys    atomic.Pointer[keySet]
+	modTime time.Time
+	size    int64
+}
+
+func NewJWT(cfg config.JWTConfig, logger *zap.Logger) (*JWT, error) {
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = defaultRoleClaim
+	}
+	if cfg.ChannelsClaim == "" {
+		cfg.ChannelsClaim = defaultChannelsClaim
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultReloadInterval
+	}
+
+	j := &JWT{
+		logger: logger,
+		cfg:    cfg,
+	}
+
+	if _, err := j.reload(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Watch reloads the key set whenever the file changes until ctx is done. A key
+// set that fails to load is logged and the previous one stays in use.
+func (j *JWT) Watch(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := j.reload()
+			if err != nil {
+				j.logger.Error("reload key set", zap.String("path", j.cfg.JWKSFile), zap.Error(err))
+				continue
+			}
+			if reloaded {
+				j.logger.Info("key set reloaded", zap.String("path", j.cfg.JWKSFile))
+			}
+		}
+	}
+}
+
+// reload loads the key set if the file has been modified since the last load.
+func (j *JWT) reload() (bool, error) {
+	info, err := os.Stat(j.cfg.JWKSFile)
+	if err != nil {
+		return false, err
+	}
+	if j.keys.Load() != nil && info.ModTime().Equal(j.modTime) && info.Size() == j.size {
+		return false, nil
+	}
+
+	keys, err := loadKeySet(j.cfg.JWKSFile)
+	if err != nil {
+		return false, err
+	}
+
+	j.keys.Store(keys)
+	j.modTime = info.ModTime()
+	j.size = info.Size()
+	return true, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (Identity, error) {
+	token, ok := Token(r)
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+
+	identity, err := j.verify(token)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return identity, nil
+}
+
+func (j *JWT) verify(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, errMalformedToken
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: signature: %w", errMalformedToken, err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range j.keys.Load().find(header.Kid, header.Alg) {
+		if verifySignature(key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Identity{}, fmt.Errorf("%w: signature does not match any key", errInvalidToken)
+	}
+
+	claims := map[string]any{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, err
+	}
+	return j.identity(claims)
+}
+
+func verifySignature(key verificationKey, signed, signature []byte) bool {
+	hash := sha256.Sum256(signed)
+
+	switch key.alg {
+	case algHS256:
+		mac := hmac.New(sha256.New, key.key.([]byte))
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case algRS256:
+		return rsa.VerifyPKCS1v15(key.key.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil
+	case algEdDSA:
+		return ed25519.Verify(key.key.(ed25519.PublicKey), signed, signature)
+	default:
+		return false
+	}
+}
+
+// identity validates registered claims and maps the configured ones to permissions.
+func (j *JWT) identity(claims map[string]any) (Identity, error) {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	switch {
+	case !ok && !j.cfg.AllowNoExpiry:
+		return Identity{}, fmt.Errorf("%w: no expiry", errInvalidToken)
+	case ok && now.After(time.Unix(int64(exp), 0).Add(jwtClockSkew)):
+		return Identity{}, fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return Identity{}, fmt.Errorf("%w: not valid yet", errInvalidToken)
+	}
+	if j.cfg.Issuer != "" && claims["iss"] != j.cfg.Issuer {
+		return Identity{}, fmt.Errorf("%w: issuer", errInvalidToken)
+	}
+	if j.cfg.Audience != "" && !containsString(claims["aud"], j.cfg.Audience) {
+		return Identity{}, fmt.Errorf("%w: audience", errInvalidToken)
+	}
+
+	role, _ := claims[j.cfg.RoleClaim].(string)
+	switch Role(role) {
+	case RolePublish, RoleSubscribe, RoleBoth:
+	default:
+		return Identity{}, fmt.Errorf("%w: claim '%s' has unknown role '%s'", errInvalidToken, j.cfg.RoleClaim, role)
+	}
+
+	channels, ok := stringList(claims[j.cfg.ChannelsClaim])
+	if !ok || len(channels) == 0 {
+		return Identity{}, fmt.Errorf("%w: claim '%s' has no channels", errInvalidToken, j.cfg.ChannelsClaim)
+	}
+
+	name, _ := claims["sub"].(string)
+	if name == "" {
+		name = "jwt"
+	}
+
+	return Identity{Name: name, Role: Role(role), Channels: channels}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errMalformedToken, err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: %w", errMalformedToken, err)
+	}
+	return nil
+}
+
+// stringList accepts a single string or an array of strings.
+func stringList(claim any) ([]string, bool) {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}, true
+	case []any:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, s)
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+func containsString(claim any, want string) bool {
+	list, _ := stringList(claim)
+	for _, s := range list {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ed     ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return testKeys{secret: []byte("0123456789abcdef0123456789abcdef"), rsa: rsaKey, ed: edKey}
+}
+
+func (k testKeys) jwks() []byte {
+	doc := map[string]any{
+		"keys": []map[string]string{
+			{"kty": "oct", "kid": "hs", "k": b64.EncodeToString(k.secret)},
+			{
+				"kty": "RSA", "kid": "rs", "alg": "RS256",
+				"n": b64.EncodeToString(k.rsa.N.Bytes()),
+				"e": b64.EncodeToString(big.NewInt(int64(k.rsa.E)).Bytes()),
+			},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(k.ed.Public().(ed25519.PublicKey))},
+		},
+	}
+	raw, _ := json.Marshal(doc)
+	return raw
+}
+
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+
+	var signature []byte
+	switch alg {
+	case algHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case algRS256:
+		hash := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, hash[:])
+		require.NoError(t, err)
+	case algEdDSA:
+		signature = ed25519.Sign(k.ed, []byte(signed))
+	}
+
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+func writeJWKS(t *testing.T, path string, data []byte) {
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys.jwks())
+
+	j, err := NewJWT(config.JWTConfig{JWKSFile: path, Issuer: "idp", Audience: "signals"}, zap.NewNop())
+	require.NoError(t, err)
+
+	valid := func() map[string]any {
+		return map[string]any{
+			"sub":      "press-1",
+			"iss":      "idp",
+			"aud":      []string{"signals"},
+			"exp":      time.Now().Add(time.Hour).Unix(),
+			"role":     "publish",
+			"channels": []string{"press"},
+		}
+	}
+	with := func(key string, value any) map[string]any {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		expectErr bool
+	}{
+		{name: "HS256", token: keys.sign(t, algHS256, "hs", valid()), expectErr: false},
+		{name: "RS256", token: keys.sign(t, algRS256, "rs", valid()), expectErr: false},
+		{name: "EdDSA", token: keys.sign(t, algEdDSA, "ed", valid()), expectErr: false},
+		{name: "no kid", token: keys.sign(t, algEdDSA, "", valid()), expectErr: false},
+		{name: "alg does not match key", token: keys.sign(t, algHS256, "rs", valid()), expectErr: true},
+		{name: "alg none", token: keys.sign(t, "none", "hs", valid()), expectErr: true},
+		{name: "no expiry", token: keys.sign(t, algHS256, "hs", with("exp", nil)), expectErr: true},
+		{name: "expired", token: keys.sign(t, algHS256, "hs", with("exp", time.Now().Add(-time.Hour).Unix())), expectErr: true},
+		{name: "not valid yet", token: keys.sign(t, algHS256, "hs", with("nbf", time.Now().Add(time.Hour).Unix())), expectErr: true},
+		{name: "wrong issuer", token: keys.sign(t, algHS256, "hs", with("iss", "other")), expectErr: true},
+		{name: "wrong audience", token: keys.sign(t, algHS256, "hs", with("aud", "other")), expectErr: true},
+		{name: "unknown role", token: keys.sign(t, algHS256, "hs", with("role", "admin")), expectErr: true},
+		{name: "no channels", token: keys.sign(t, algHS256, "hs", with("channels", nil)), expectErr: true},
+		{name: "tampered", token: keys.sign(t, algHS256, "hs", valid()) + "x", expectErr: true},
+		{name: "malformed", token: "a.b", expectErr: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest("GET", "/connection/press", nil)
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+
+			identity, err := j.Authenticate(r)
+			if tc.expectErr {
+				assert.ErrorIs(t, err, ErrUnauthorized)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, Identity{Name: "press-1", Role: RolePublish, Channels: []string{"press"}}, identity)
+		})
+	}
+}
+
+func TestJWTReload(t *testing.T) {
+	t.Parallel()
+
+	oldKeys, newKeys := newTestKeys(t), newTestKeys(t)
+	newKeys.secret = []byte("fedcba9876543210fedcba9876543210")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, oldKeys.jwks())
+
+	j, err := NewJWT(config.JWTConfig{JWKSFile: path}, zap.NewNop())
+	require.NoError(t, err)
+
+	claims := map[string]any{"role": "subscribe", "channels": "hall", "exp": time.Now().Add(time.Hour).Unix()}
+	oldToken, newToken := oldKeys.sign(t, algHS256, "hs", claims), newKeys.sign(t, algHS256, "hs", claims)
+
+	_, err = j.verify(oldToken)
+	assert.NoError(t, err)
+	_, err = j.verify(newToken)
+	assert.Error(t, err)
+
+	writeJWKS(t, path, newKeys.jwks())
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	reloaded, err := j.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+
+	_, err = j.verify(oldToken)
+	assert.Error(t, err)
+	_, err = j.verify(newToken)
+	assert.NoError(t, err)
+
+	writeJWKS(t, path, []byte("{broken"))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)))
+	_, err = j.reload()
+	assert.Error(t, err)
+	_, err = j.verify(newToken)
+	assert.NoError(t, err)
+}
+
+func TestJWTAllowNoExpiry(t *testing.T) {
+	t.Parallel()
+
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys.jwks())
+
+	j, err := NewJWT(config.JWTConfig{JWKSFile: path, AllowNoExpiry: true}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = j.verify(keys.sign(t, algHS256, "hs", map[string]any{"role": "subscribe", "channels": "hall"}))
+	assert.NoError(t, err)
+	_, err = j.verify(keys.sign(t, algHS256, "hs", map[string]any{"role": "subscribe", "channels": "hall", "exp": time.Now().Add(-time.Hour).Unix()}))
+	assert.Error(t, err)
+}
+
+func TestJWTWeakRSAKey(t *testing.T) {
+	t.Parallel()
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	keys := newTestKeys(t)
+	keys.rsa = weak
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys.jwks())
+
+	_, err = NewJWT(config.JWTConfig{JWKSFile: path}, zap.NewNop())
+	assert.ErrorIs(t, err, errUnsupportedKey)
+}
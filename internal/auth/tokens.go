@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/serg-pe/signals/internal/config"
+)
+
+type staticToken struct {
+	hash     [sha256.Size]byte
+	identity Identity
+}
+
+// Tokens authenticates requests with static tokens from the config.
+type Tokens struct {
+	tokens []staticToken
+}
+
+func NewTokens(cfg []config.TokenConfig) (*Tokens, error) {
+	t := &Tokens{
+		tokens: make([]staticToken, 0, len(cfg)),
+	}
+
+	for i, tokenCfg := range cfg {
+		if tokenCfg.Token == "" {
+			return nil, fmt.Errorf("token %d: empty token", i)
+		}
+
+		role := Role(tokenCfg.Role)
+		switch role {
+		case RolePublish, RoleSubscribe, RoleBoth:
+		default:
+			return nil, fmt.Errorf("token %d: unknown role '%s', allowed %s, %s or %s", i, tokenCfg.Role, RolePublish, RoleSubscribe, RoleBoth)
+		}
+
+		name := tokenCfg.Name
+		if name == "" {
+			name = fmt.Sprintf("token %d", i)
+		}
+
+		t.tokens = append(t.tokens, staticToken{
+			hash: sha256.Sum256([]byte(tokenCfg.Token)),
+			identity: Identity{
+				Name:     name,
+				Role:     role,
+				Channels: tokenCfg.Channels,
+			},
+		})
+	}
+
+	return t, nil
+}
+
+func (t *Tokens) Authenticate(r *http.Request) (Identity, error) {
+	token, ok := Token(r)
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	for _, known := range t.tokens {
+		if subtle.ConstantTimeCompare(hash[:], known.hash[:]) == 1 {
+			return known.identity, nil
+		}
+	}
+	return Identity{}, ErrUnauthorized
+}
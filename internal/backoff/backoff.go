@@ -0,0 +1,13 @@
+// Package backoff spaces out the retries of reconnecting clients and deliveries.
+package backoff
+
+import "time"
+
+// Delay doubles minDelay with every attempt, staying within [minDelay, maxDelay].
+func Delay(attempt int, minDelay, maxDelay time.Duration) time.Duration {
+	delay := minDelay
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
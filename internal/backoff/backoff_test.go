@@ -0,0 +1,56 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		attempt  int
+		min      time.Duration
+		max      time.Duration
+		expected time.Duration
+	}{
+		{
+			name:     "first attempt",
+			attempt:  0,
+			min:      time.Second,
+			max:      time.Minute,
+			expected: time.Second,
+		},
+		{
+			name:     "doubles",
+			attempt:  3,
+			min:      time.Second,
+			max:      time.Minute,
+			expected: time.Second * 8,
+		},
+		{
+			name:     "capped",
+			attempt:  10,
+			min:      time.Second,
+			max:      time.Minute,
+			expected: time.Minute,
+		},
+		{
+			name:     "huge attempt",
+			attempt:  1000,
+			min:      time.Second,
+			max:      time.Minute,
+			expected: time.Minute,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, Delay(tc.attempt, tc.min, tc.max))
+		})
+	}
+}
@@ -1,40 +1,291 @@
 package client
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/serg-pe/signals/pkg/types/queue"
 	"go.uber.org/zap"
 )
 
+type Role byte
+
+const (
+	RoleSubscriber Role = iota
+	RolePublisher
+)
+
+func (r Role) String() string {
+	switch r {
+	case RolePublisher:
+		return "publisher"
+	default:
+		return "subscriber"
+	}
+}
+
+// Options tune the heartbeat and the send queue of a client. Zero heartbeat
+// values disable the respective check.
+type Options struct {
+	// PingInterval is the period of transport pings sent to the client.
+	PingInterval time.Duration
+	// PongTimeout is how long to wait for a pong after a ping before the
+	// connection is considered dead.
+	PongTimeout time.Duration
+	// IdleTimeout evicts a client that sends no messages for that long.
+	IdleTimeout time.Duration
+
+	// QueueSize bounds the number of messages waiting to be written.
+	QueueSize int
+	// SlowConsumer is applied when the queue is full.
+	SlowConsumer SlowConsumerPolicy
+	// WriteTimeout limits a single write to the connection.
+	WriteTimeout time.Duration
+
+	Hooks Hooks
+}
+
+// Hooks observe the traffic of a client, nil hooks are skipped. They are called
+// from the reading and writing goroutines and must not block.
+type Hooks struct {
+	// Received is called for every decoded message.
+	Received func(signal signals.Signal)
+	// Delivered is called for every message written to the connection.
+	Delivered func(signal signals.Signal)
+	// Dropped is called for every message discarded by the slow consumer policy.
+	Dropped func()
+}
+
+// SignalHandler is called for every state signal received from a publisher
+// and for every acknowledgement received from a subscriber.
+type SignalHandler func(from *Client, msg signals.Message)
+
 type Client struct {
-	logger *zap.Logger
-	conn   *websocket.Conn
-	stop   chan struct{}
+	id        int
+	identity  string
+	logger    *zap.Logger
+	transport Transport
+	channel   string
+	role      Role
+	version   uint8
+	json      bool
+	stop      chan struct{}
+
+	// resumeAfter is the last sequence number the client has seen before it reconnected.
+	resumeAfter uint32
+	resuming    bool
+
+	stopOnce *sync.Once
+	// deadlineMu keeps pong handlers from extending the read deadline set by Stop.
+	deadlineMu *sync.Mutex
+
+	opts         Options
+	connectedAt  time.Time
+	lastActivity *atomic.Int64
+	received     *atomic.Uint64
+	sent         *atomic.Uint64
+
+	onSignal SignalHandler
+
+	// outbox is consumed by the writer goroutine, see write. backlog is
+	// written ahead of it and is not bounded, see Replay.
+	outMu      *sync.Mutex
+	outbox     queue.Queue[outbound]
+	backlog    queue.Queue[outbound]
+	outClosed  bool
+	wake       chan struct{}
+	writerStop chan struct{}
+	writerDone chan struct{}
+	// closeCode and closeReason are sent by the writer after the queue is flushed, see Shutdown.
+	closeCode    int
+	closeReason  string
+	shutdownOnce *sync.Once
+	dropped      *atomic.Uint64
 }
 
-func New(logger *zap.Logger, conn *websocket.Conn) *Client {
-	return &Client{
-		logger: logger,
-		conn:   conn,
-		stop:   make(chan struct{}),
+// New serves a client over the transport. The protocol version and encoding
+// are taken from the protocol of the transport.
+func New(logger *zap.Logger, transport Transport, opts Options, channel string, role Role, onSignal SignalHandler) *Client {
+	var (
+		version = signals.LatestVersion
+		isJSON  = transport.Protocol() == signals.SubprotocolJSON
+		err     error
+	)
+	if !isJSON {
+		version, err = signals.VersionFromSubprotocol(transport.Protocol())
+		if err != nil {
+			logger.Warn("fallback to legacy protocol", zap.Error(err))
+			version = signals.VersionLegacy
+		}
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.SlowConsumer == "" {
+		opts.SlowConsumer = DropOldest
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defaultWriteTimeout
 	}
+
+	c := &Client{
+		logger:     logger,
+		transport:  transport,
+		channel:    channel,
+		role:       role,
+		version:    version,
+		json:       isJSON,
+		stop:       make(chan struct{}),
+		stopOnce:   &sync.Once{},
+		deadlineMu: &sync.Mutex{},
+		onSignal:   onSignal,
+
+		opts:         opts,
+		connectedAt:  time.Now(),
+		lastActivity: &atomic.Int64{},
+		received:     &atomic.Uint64{},
+		sent:         &atomic.Uint64{},
+
+		outMu:      &sync.Mutex{},
+		outbox:     queue.New[outbound](),
+		backlog:    queue.New[outbound](),
+		wake:       make(chan struct{}, 1),
+		writerStop: make(chan struct{}),
+		writerDone: make(chan struct{}),
+		dropped:    &atomic.Uint64{},
+
+		shutdownOnce: &sync.Once{},
+	}
+	c.lastActivity.Store(c.connectedAt.UnixNano())
+
+	go c.write()
+
+	return c
+}
+
+// SetID assigns the registry id of the client and names its logger after it.
+func (c *Client) SetID(id int) {
+	c.id = id
+	c.logger = c.logger.Named(fmt.Sprintf("%s %d", c.role, id))
+}
+
+// SetIdentity records who the client has authenticated as.
+func (c *Client) SetIdentity(identity string) {
+	c.identity = identity
+}
+
+func (c *Client) Identity() string {
+	return c.identity
+}
+
+func (c *Client) ID() int {
+	return c.id
+}
+
+func (c *Client) Channel() string {
+	return c.channel
+}
+
+func (c *Client) Role() Role {
+	return c.role
+}
+
+func (c *Client) Version() uint8 {
+	return c.version
+}
+
+// Sequenced reports whether the client protocol carries sequence numbers.
+func (c *Client) Sequenced() bool {
+	return c.json || c.version != signals.VersionLegacy
+}
+
+// Protocol returns the negotiated subprotocol, empty for the legacy protocol.
+func (c *Client) Protocol() string {
+	return c.transport.Protocol()
+}
+
+// Transport returns the name of the transport serving the client.
+func (c *Client) Transport() string {
+	return c.transport.Name()
+}
+
+func (c *Client) RemoteAddr() string {
+	return c.transport.RemoteAddr()
+}
+
+// SetResumeAfter records the sequence number of the last state signal the
+// client has received, so it is not replayed again.
+func (c *Client) SetResumeAfter(seq uint32) {
+	c.resumeAfter = seq
+	c.resuming = true
+}
+
+// ResumeAfter returns the sequence number set by SetResumeAfter, if any.
+func (c *Client) ResumeAfter() (uint32, bool) {
+	return c.resumeAfter, c.resuming
+}
+
+func (c *Client) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// LastActivity returns when the client sent its last message.
+func (c *Client) LastActivity() time.Time {
+	return time.Unix(0, c.lastActivity.Load())
+}
+
+// Received returns the number of messages decoded from the client.
+func (c *Client) Received() uint64 {
+	return c.received.Load()
+}
+
+// Sent returns the number of messages written to the client.
+func (c *Client) Sent() uint64 {
+	return c.sent.Load()
 }
 
+// Listen reads messages until the connection fails, the heartbeat times out or
+// Stop is called. The connection stays open afterwards, so the owner must call Close.
 func (c *Client) Listen() {
-	defer c.close()
+	if c.opts.PingInterval > 0 {
+		c.extendReadDeadline()
+		c.transport.SetPongHandler(c.extendReadDeadline)
+	}
 
-	msgBuf := make([]byte, 1)
+	// The heartbeat must not ping once Listen returned, the transport may be gone by then.
+	stopHeartbeat, heartbeatDone := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stopHeartbeat)
+		<-heartbeatDone
+	}()
+	go func() {
+		defer close(heartbeatDone)
+		c.heartbeat(stopHeartbeat)
+	}()
 
 	for {
 		select {
 		case <-c.stop:
 			return
 		default:
-			msgType, msg, err := c.conn.ReadMessage()
-			if err != nil && websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-				c.logger.Debug("client closed connection", zap.Error(err))
+			msg, err := c.transport.ReadMessage()
+			if c.stopped() {
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				c.logger.Debug("client closed connection")
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				c.logger.Info("heartbeat timeout, connection is dead")
 				return
 			}
 			if err != nil {
@@ -42,17 +293,34 @@ func (c *Client) Listen() {
 				return
 			}
 
-			if msgType != websocket.BinaryMessage {
-				c.logger.Debug("non binary msg type", zap.Int("type", msgType))
+			decoded, err := c.decode(msg)
+			if err != nil {
+				c.logger.Debug("decode message", zap.Error(err))
 				continue
 			}
+			c.lastActivity.Store(time.Now().UnixNano())
+			c.received.Add(1)
+			if c.opts.Hooks.Received != nil {
+				c.opts.Hooks.Received(decoded.Signal)
+			}
 
-			switch signals.Signal(msg[0]) {
+			switch decoded.Signal {
 			case signals.SignalPing:
-				msgBuf[0] = byte(signals.SignalPong)
-				err = c.conn.WriteMessage(websocket.BinaryMessage, msgBuf)
+				err = c.Send(signals.Message{Signal: signals.SignalPong, Seq: decoded.Seq})
+			case signals.SignalOn, signals.SignalOff:
+				if c.role != RolePublisher {
+					c.logger.Debug("signal from subscriber ignored", zap.Uint8("signal", uint8(decoded.Signal)))
+					continue
+				}
+				c.handle(decoded)
+			case signals.SignalAck:
+				if c.role != RoleSubscriber {
+					c.logger.Debug("ack from publisher ignored")
+					continue
+				}
+				c.handle(decoded)
 			default:
-				c.logger.Debug("got message", zap.Int8("msg", int8(msg[0])))
+				c.logger.Debug("got message", zap.Uint8("signal", uint8(decoded.Signal)))
 			}
 			if err != nil {
 				c.logger.Debug("reply client error", zap.Error(err))
@@ -62,16 +330,124 @@ func (c *Client) Listen() {
 	}
 }
 
-func (c *Client) Stop() {
-	c.stop <- struct{}{}
+func (c *Client) handle(msg signals.Message) {
+	if c.onSignal != nil {
+		c.onSignal(c, msg)
+	}
 }
 
-func (c *Client) close() {
-	time.Sleep(time.Second * 5)
-	err := c.conn.Close()
+// Send encodes the message with the negotiated protocol and queues it for the
+// writer goroutine. It never blocks and is safe for concurrent use.
+func (c *Client) Send(msg signals.Message) error {
+	data, err := c.encode(msg)
 	if err != nil {
+		return err
+	}
+
+	return c.enqueue(outbound{msg: msg, data: data})
+}
+
+func (c *Client) encode(msg signals.Message) ([]byte, error) {
+	if c.json {
+		return signals.EncodeJSON(msg)
+	}
+	return signals.Encode(c.version, msg)
+}
+
+func (c *Client) decode(data []byte) (signals.Message, error) {
+	if c.json {
+		return signals.DecodeJSON(data)
+	}
+	return signals.Decode(c.version, data)
+}
+
+// Stop asks Listen to return and unblocks a pending read. Safe to call more than once.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		c.deadlineMu.Lock()
+		defer c.deadlineMu.Unlock()
+
+		close(c.stop)
+		if err := c.transport.SetReadDeadline(time.Now()); err != nil {
+			c.logger.Debug("interrupt read", zap.Error(err))
+		}
+	})
+}
+
+func (c *Client) extendReadDeadline() {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	if c.stopped() {
+		return
+	}
+	if err := c.transport.SetReadDeadline(time.Now().Add(c.opts.PingInterval + c.opts.PongTimeout)); err != nil {
+		c.logger.Debug("extend read deadline", zap.Error(err))
+	}
+}
+
+// heartbeat pings the client and evicts it once it has been idle for too long.
+func (c *Client) heartbeat(stop <-chan struct{}) {
+	period := c.opts.PingInterval
+	if period <= 0 || (c.opts.IdleTimeout > 0 && c.opts.IdleTimeout < period) {
+		period = c.opts.IdleTimeout
+	}
+	if period <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if c.opts.IdleTimeout > 0 && time.Since(time.Unix(0, c.lastActivity.Load())) > c.opts.IdleTimeout {
+			c.logger.Info("idle timeout, evicting client", zap.Duration("timeout", c.opts.IdleTimeout))
+			c.Stop()
+			return
+		}
+
+		if c.opts.PingInterval > 0 {
+			err := c.transport.Ping(time.Now().Add(c.opts.PongTimeout + time.Second))
+			if err != nil {
+				c.logger.Debug("ping client", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close flushes queued messages, sends a normal close frame unless Shutdown
+// already sent one and closes the transport.
+func (c *Client) Close() {
+	c.Shutdown(websocket.CloseNormalClosure, "")
+	<-c.writerDone
+
+	err := c.transport.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
 		c.logger.Error("close error", zap.Error(err))
 	}
-	c.conn = nil
 	c.logger.Info("client disconnected")
 }
+
+// Terminate stops the client and closes the connection at once, pending
+// writes fail. The owner still has to call Close.
+func (c *Client) Terminate() {
+	c.Stop()
+	if err := c.transport.Close(); err != nil {
+		c.logger.Debug("terminate client", zap.Error(err))
+	}
+}
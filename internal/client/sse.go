@@ -0,0 +1,190 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/pkg/signals"
+)
+
+const transportSSE = "sse"
+
+// sseTransport streams messages as server-sent events. Every message is an
+// event named after its signal with the JSON message as data, state signals
+// carry their sequence number as the event id. The peer cannot send messages.
+type sseTransport struct {
+	w          http.ResponseWriter
+	rc         *http.ResponseController
+	remoteAddr string
+	// done is closed when the peer goes away.
+	done <-chan struct{}
+
+	writeMu *sync.Mutex
+	pong    func()
+
+	closed    chan struct{}
+	closeOnce *sync.Once
+
+	deadline *readDeadline
+}
+
+// NewSSE starts an event stream on the response. The transport is only valid
+// until the handler returns, so the handler must serve the client itself.
+func NewSSE(w http.ResponseWriter, r *http.Request) (Transport, error) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the timeouts of the http server, writes set their own deadlines.
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("disable read deadline: %w", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("disable write deadline: %w", err)
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Keeps nginx from buffering the stream.
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("flush stream: %w", err)
+	}
+
+	return &sseTransport{
+		w:          w,
+		rc:         rc,
+		remoteAddr: r.RemoteAddr,
+		done:       r.Context().Done(),
+
+		writeMu: &sync.Mutex{},
+
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+
+		deadline: newReadDeadline(),
+	}, nil
+}
+
+func (t *sseTransport) Name() string {
+	return transportSSE
+}
+
+func (t *sseTransport) Protocol() string {
+	return signals.SubprotocolJSON
+}
+
+func (t *sseTransport) RemoteAddr() string {
+	return t.remoteAddr
+}
+
+// ReadMessage blocks until the peer goes away, the transport is closed or the read deadline passes.
+func (t *sseTransport) ReadMessage() ([]byte, error) {
+	for {
+		expired, stop := t.deadline.timer()
+
+		var err error
+		select {
+		case <-t.done:
+			err = io.EOF
+		case <-t.closed:
+			err = io.EOF
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-t.deadline.changed:
+		}
+		stop()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *sseTransport) SetReadDeadline(deadline time.Time) error {
+	t.deadline.set(deadline)
+	return nil
+}
+
+func (t *sseTransport) WriteMessage(msg signals.Message, data []byte, deadline time.Time) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if t.isClosed() {
+		return io.ErrClosedPipe
+	}
+
+	if err := t.rc.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	if msg.Seq != 0 && (msg.Signal == signals.SignalOn || msg.Signal == signals.SignalOff) {
+		if _, err := fmt.Fprintf(t.w, "id: %d\n", msg.Seq); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(t.w, "event: %s\ndata: %s\n\n", msg.Signal, data); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+// Ping writes a comment line. The peer never answers, a comment that reached
+// the connection counts as answered.
+func (t *sseTransport) Ping(deadline time.Time) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if t.isClosed() {
+		return io.ErrClosedPipe
+	}
+
+	if err := t.rc.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(t.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	if err := t.rc.Flush(); err != nil {
+		return err
+	}
+
+	if t.pong != nil {
+		t.pong()
+	}
+	return nil
+}
+
+func (t *sseTransport) SetPongHandler(pong func()) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.pong = pong
+}
+
+// WriteClose ends the stream, event streams have no close handshake.
+func (t *sseTransport) WriteClose(int, string, time.Time) error {
+	return t.Close()
+}
+
+// Close ends the stream, the response must not be written afterwards.
+func (t *sseTransport) Close() error {
+	t.closeOnce.Do(func() {
+		t.writeMu.Lock()
+		defer t.writeMu.Unlock()
+
+		close(t.closed)
+	})
+	return nil
+}
+
+func (t *sseTransport) isClosed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/pkg/signals"
+)
+
+const (
+	transportTCP = "tcp"
+
+	frameHeaderLen = 4
+)
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// ReadFrame reads a frame of a plain TCP connection: a big endian uint32
+// length followed by that many bytes. Empty frames are keep-alives.
+func ReadFrame(r io.Reader, maxLen int) ([]byte, error) {
+	header := make([]byte, frameHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > uint32(maxLen) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteFrame writes data as a single length prefixed frame.
+func WriteFrame(w io.Writer, data []byte) error {
+	frame := make([]byte, frameHeaderLen+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[frameHeaderLen:], data)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// tcpTransport frames messages of a plain TCP connection with their length,
+// see ReadFrame. The protocol is agreed on before the transport is created.
+type tcpTransport struct {
+	conn     net.Conn
+	protocol string
+
+	writeMu *sync.Mutex
+	pong    func()
+}
+
+// NewTCP wraps a connection that has already sent its hello frame.
+func NewTCP(conn net.Conn, protocol string) Transport {
+	return &tcpTransport{conn: conn, protocol: protocol, writeMu: &sync.Mutex{}}
+}
+
+func (t *tcpTransport) Name() string {
+	return transportTCP
+}
+
+func (t *tcpTransport) Protocol() string {
+	return t.protocol
+}
+
+func (t *tcpTransport) RemoteAddr() string {
+	return t.conn.RemoteAddr().String()
+}
+
+// ReadMessage skips keep-alive frames.
+func (t *tcpTransport) ReadMessage() ([]byte, error) {
+	for {
+		data, err := ReadFrame(t.conn, signals.MaxMessageLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			return data, nil
+		}
+	}
+}
+
+func (t *tcpTransport) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+func (t *tcpTransport) WriteMessage(_ signals.Message, data []byte, deadline time.Time) error {
+	return t.write(data, deadline)
+}
+
+// Ping writes a keep-alive frame. Devices are not expected to answer, so a
+// frame that reached the connection counts as answered; dead peers are found
+// by the TCP keep-alive of the listener.
+func (t *tcpTransport) Ping(deadline time.Time) error {
+	if err := t.write(nil, deadline); err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	pong := t.pong
+	t.writeMu.Unlock()
+	if pong != nil {
+		pong()
+	}
+	return nil
+}
+
+func (t *tcpTransport) SetPongHandler(pong func()) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.pong = pong
+}
+
+// WriteClose half-closes the connection, the peer answers by closing its side.
+// The close code has no meaning without websockets.
+func (t *tcpTransport) WriteClose(_ int, _ string, _ time.Time) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if conn, ok := t.conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return t.conn.Close()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *tcpTransport) write(data []byte, deadline time.Time) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if err := t.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return WriteFrame(t.conn, data)
+}
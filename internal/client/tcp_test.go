@@ -0,0 +1,48 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFrame(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []byte
+		err      error
+	}{
+		{name: "message", data: []byte{0, 0, 0, 2, 1, 2}, expected: []byte{1, 2}},
+		{name: "keep-alive", data: []byte{0, 0, 0, 0}, expected: []byte{}},
+		{name: "closed", data: []byte{}, err: io.EOF},
+		{name: "truncated", data: []byte{0, 0, 0, 2, 1}, err: io.ErrUnexpectedEOF},
+		{name: "too large", data: []byte{0, 0, 0, 9}, err: ErrFrameTooLarge},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := ReadFrame(bytes.NewReader(tc.data), 8)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, data)
+		})
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteFrame(buf, []byte{7}))
+	assert.Equal(t, []byte{0, 0, 0, 1, 7}, buf.Bytes())
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/pkg/signals"
+)
+
+// Transport moves encoded messages between the server and a client, so the
+// same Client serves websocket, server-sent events and other connections.
+type Transport interface {
+	// Name identifies the transport in logs and the admin API.
+	Name() string
+	// Protocol names the encoding of messages like the websocket subprotocols
+	// of pkg/signals do, empty means the legacy protocol.
+	Protocol() string
+	RemoteAddr() string
+
+	// ReadMessage blocks until a message arrives. It returns a net.Error with
+	// Timeout after the read deadline and io.EOF once the peer closed the transport.
+	ReadMessage() ([]byte, error)
+	SetReadDeadline(t time.Time) error
+	// WriteMessage writes an encoded message. msg is its decoded form for
+	// transports that frame messages by their content.
+	WriteMessage(msg signals.Message, data []byte, deadline time.Time) error
+
+	// Ping writes a keep-alive, the pong handler is called once the peer answers.
+	Ping(deadline time.Time) error
+	SetPongHandler(pong func())
+	// WriteClose announces that the server closes the transport, with a
+	// websocket close code. ReadMessage returns io.EOF once the peer agrees.
+	WriteClose(code int, reason string, deadline time.Time) error
+	Close() error
+}
+
+// readDeadline lets transports without a connection of their own honour
+// SetReadDeadline while they wait for messages on channels.
+type readDeadline struct {
+	mu       *sync.Mutex
+	deadline time.Time
+	changed  chan struct{}
+}
+
+func newReadDeadline() *readDeadline {
+	return &readDeadline{mu: &sync.Mutex{}, changed: make(chan struct{}, 1)}
+}
+
+func (d *readDeadline) set(deadline time.Time) {
+	d.mu.Lock()
+	d.deadline = deadline
+	d.mu.Unlock()
+
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+// timer returns a channel that fires once the current deadline passes, nil
+// without a deadline. stop must be called once the caller stops waiting.
+func (d *readDeadline) timer() (expired <-chan time.Time, stop func()) {
+	d.mu.Lock()
+	deadline := d.deadline
+	d.mu.Unlock()
+
+	if deadline.IsZero() {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	return timer.C, func() { timer.Stop() }
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/pkg/signals"
+)
+
+const (
+	transportUDP = "udp"
+
+	udpInboxSize = 16
+)
+
+// UDPTransport is a session of a peer on a shared UDP socket. Every datagram
+// carries one message. The owner of the socket reads it and hands the
+// datagrams of the peer to Deliver.
+type UDPTransport struct {
+	pc       net.PacketConn
+	addr     net.Addr
+	protocol string
+
+	inbox chan []byte
+
+	closed    chan struct{}
+	closeOnce *sync.Once
+
+	pongMu *sync.Mutex
+	pong   func()
+
+	deadline *readDeadline
+}
+
+// NewUDP starts a session with the peer at addr, messages are written to it through pc.
+func NewUDP(pc net.PacketConn, addr net.Addr, protocol string) *UDPTransport {
+	return &UDPTransport{
+		pc:       pc,
+		addr:     addr,
+		protocol: protocol,
+
+		inbox: make(chan []byte, udpInboxSize),
+
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+
+		pongMu: &sync.Mutex{},
+
+		deadline: newReadDeadline(),
+	}
+}
+
+// Deliver queues a datagram of the peer. Datagrams that do not fit into the
+// inbox are dropped like the network would, Deliver reports whether it was queued.
+func (t *UDPTransport) Deliver(data []byte) bool {
+	if t.isClosed() {
+		return false
+	}
+
+	select {
+	case t.inbox <- append([]byte(nil), data...):
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *UDPTransport) Name() string {
+	return transportUDP
+}
+
+func (t *UDPTransport) Protocol() string {
+	return t.protocol
+}
+
+func (t *UDPTransport) RemoteAddr() string {
+	return t.addr.String()
+}
+
+// ReadMessage skips empty datagrams, devices may send them as keep-alives.
+func (t *UDPTransport) ReadMessage() ([]byte, error) {
+	for {
+		expired, stop := t.deadline.timer()
+
+		var (
+			data []byte
+			err  error
+		)
+		select {
+		case data = <-t.inbox:
+		case <-t.closed:
+			err = io.EOF
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-t.deadline.changed:
+		}
+		stop()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			return data, nil
+		}
+	}
+}
+
+func (t *UDPTransport) SetReadDeadline(deadline time.Time) error {
+	t.deadline.set(deadline)
+	return nil
+}
+
+// WriteMessage sends the message as a single datagram. The socket is shared,
+// so the deadline is not applied; datagrams are not held up by slow peers.
+func (t *UDPTransport) WriteMessage(_ signals.Message, data []byte, _ time.Time) error {
+	if t.isClosed() {
+		return io.ErrClosedPipe
+	}
+
+	_, err := t.pc.WriteTo(data, t.addr)
+	return err
+}
+
+// Ping sends nothing, datagrams cannot tell whether a peer is alive. Sessions
+// end after the idle timeout instead, see Options.IdleTimeout.
+func (t *UDPTransport) Ping(time.Time) error {
+	t.pongMu.Lock()
+	pong := t.pong
+	t.pongMu.Unlock()
+
+	if pong != nil {
+		pong()
+	}
+	return nil
+}
+
+func (t *UDPTransport) SetPongHandler(pong func()) {
+	t.pongMu.Lock()
+	defer t.pongMu.Unlock()
+
+	t.pong = pong
+}
+
+// WriteClose ends the session, UDP has no close handshake.
+func (t *UDPTransport) WriteClose(int, string, time.Time) error {
+	return t.Close()
+}
+
+// Close ends the session, the shared socket stays open.
+func (t *UDPTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
+
+func (t *UDPTransport) isClosed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/pkg/signals"
+)
+
+const transportWebsocket = "websocket"
+
+type websocketTransport struct {
+	conn    *websocket.Conn
+	msgType int
+}
+
+// NewWebsocket wraps an upgraded connection, messages are expected in the
+// frame type of the negotiated subprotocol.
+func NewWebsocket(conn *websocket.Conn) Transport {
+	conn.SetReadLimit(signals.MaxMessageLen)
+
+	msgType := websocket.BinaryMessage
+	if conn.Subprotocol() == signals.SubprotocolJSON {
+		msgType = websocket.TextMessage
+	}
+	return &websocketTransport{conn: conn, msgType: msgType}
+}
+
+func (t *websocketTransport) Name() string {
+	return transportWebsocket
+}
+
+func (t *websocketTransport) Protocol() string {
+	return t.conn.Subprotocol()
+}
+
+func (t *websocketTransport) RemoteAddr() string {
+	return t.conn.RemoteAddr().String()
+}
+
+// ReadMessage skips frames of the other type, a binary client never sends text.
+func (t *websocketTransport) ReadMessage() ([]byte, error) {
+	for {
+		msgType, data, err := t.conn.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if msgType == t.msgType {
+			return data, nil
+		}
+	}
+}
+
+func (t *websocketTransport) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
+func (t *websocketTransport) WriteMessage(_ signals.Message, data []byte, deadline time.Time) error {
+	if err := t.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return t.conn.WriteMessage(t.msgType, data)
+}
+
+func (t *websocketTransport) Ping(deadline time.Time) error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+func (t *websocketTransport) SetPongHandler(pong func()) {
+	t.conn.SetPongHandler(func(string) error {
+		pong()
+		return nil
+	})
+}
+
+func (t *websocketTransport) WriteClose(code int, reason string, deadline time.Time) error {
+	err := t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	if errors.Is(err, websocket.ErrCloseSent) {
+		return nil
+	}
+	return err
+}
+
+func (t *websocketTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultQueueSize    = 64
+	defaultWriteTimeout = time.Second * 10
+)
+
+// SlowConsumerPolicy decides what happens when the send queue of a client is full.
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one.
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// DropNewest discards the message being sent.
+	DropNewest SlowConsumerPolicy = "drop_newest"
+	// Disconnect stops the client.
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+var (
+	ErrSlowConsumer = errors.New("send queue is full")
+	ErrClosed       = errors.New("client closed")
+)
+
+func ParseSlowConsumerPolicy(raw string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(raw); policy {
+	case "":
+		return DropOldest, nil
+	case DropOldest, DropNewest, Disconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy '%s': allowed %s, %s or %s", raw, DropOldest, DropNewest, Disconnect)
+	}
+}
+
+// outbound is an encoded message waiting in the send queue.
+type outbound struct {
+	msg  signals.Message
+	data []byte
+}
+
+// enqueue puts an encoded message into the send queue and wakes the writer.
+func (c *Client) enqueue(msg outbound) error {
+	c.outMu.Lock()
+	if c.outClosed {
+		c.outMu.Unlock()
+		return ErrClosed
+	}
+
+	if c.outbox.Len() >= c.opts.QueueSize {
+		switch c.opts.SlowConsumer {
+		case DropNewest:
+			c.outMu.Unlock()
+			c.drop()
+			return ErrSlowConsumer
+		case Disconnect:
+			c.outMu.Unlock()
+			c.logger.Info("send queue is full, disconnecting slow consumer")
+			c.Stop()
+			return ErrSlowConsumer
+		default:
+			c.outbox.Pop()
+			defer c.drop()
+		}
+	}
+	c.outbox.Push(msg)
+	c.outMu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Replay queues the messages a client has missed before it joined its
+// channel. They are written ahead of everything queued with Send and are
+// neither bounded by the queue size nor subject to the slow consumer policy, so
+// a long replay loses no signals and does not evict the client.
+func (c *Client) Replay(msgs []signals.Message) error {
+	encoded := make([]outbound, 0, len(msgs))
+	for _, msg := range msgs {
+		data, err := c.encode(msg)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, outbound{msg: msg, data: data})
+	}
+
+	c.outMu.Lock()
+	if c.outClosed {
+		c.outMu.Unlock()
+		return ErrClosed
+	}
+	for _, msg := range encoded {
+		c.backlog.Push(msg)
+	}
+	c.outMu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// write is the only goroutine writing data messages to the connection. It
+// flushes the queue, sends the close frame and returns once Shutdown is called.
+func (c *Client) write() {
+	defer close(c.writerDone)
+
+	for {
+		select {
+		case <-c.wake:
+			if err := c.flush(); err != nil {
+				c.logger.Debug("write client error", zap.Error(err))
+				c.Stop()
+				c.discard()
+			}
+		case <-c.writerStop:
+			if err := c.flush(); err != nil {
+				c.logger.Debug("flush client error", zap.Error(err))
+			}
+			if err := c.transport.WriteClose(c.closeCode, c.closeReason, time.Now().Add(c.opts.WriteTimeout)); err != nil {
+				c.logger.Debug("send close frame", zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+func (c *Client) flush() error {
+	for {
+		c.outMu.Lock()
+		msg, ok := c.backlog.Pop()
+		if !ok {
+			msg, ok = c.outbox.Pop()
+		}
+		c.outMu.Unlock()
+		if !ok {
+			return nil
+		}
+
+		if err := c.transport.WriteMessage(msg.msg, msg.data, time.Now().Add(c.opts.WriteTimeout)); err != nil {
+			return err
+		}
+		c.sent.Add(1)
+		if c.opts.Hooks.Delivered != nil {
+			c.opts.Hooks.Delivered(msg.msg.Signal)
+		}
+	}
+}
+
+// discard drops queued messages and refuses new ones after the connection broke.
+func (c *Client) discard() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	c.outClosed = true
+	for _, ok := c.backlog.Pop(); ok; _, ok = c.backlog.Pop() {
+	}
+	for _, ok := c.outbox.Pop(); ok; _, ok = c.outbox.Pop() {
+	}
+}
+
+// Shutdown refuses new messages, flushes the queued ones and sends a close
+// frame with the code. It does not wait: Listen returns once the peer answers
+// with its own close frame, the owner should Stop the client if that takes too long.
+func (c *Client) Shutdown(code int, reason string) {
+	c.shutdownOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+
+		c.outMu.Lock()
+		c.outClosed = true
+		c.outMu.Unlock()
+
+		close(c.writerStop)
+	})
+}
+
+func (c *Client) drop() {
+	c.dropped.Add(1)
+	if c.opts.Hooks.Dropped != nil {
+		c.opts.Hooks.Dropped()
+	}
+}
+
+// Dropped returns the number of messages dropped by the slow consumer policy.
+func (c *Client) Dropped() uint64 {
+	return c.dropped.Load()
+}
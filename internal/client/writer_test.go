@@ -0,0 +1,170 @@
+package client
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		raw       string
+		expected  SlowConsumerPolicy
+		expectErr bool
+	}{
+		{raw: "", expected: DropOldest, expectErr: false},
+		{raw: "drop_oldest", expected: DropOldest, expectErr: false},
+		{raw: "drop_newest", expected: DropNewest, expectErr: false},
+		{raw: "disconnect", expected: Disconnect, expectErr: false},
+		{raw: "block", expectErr: true},
+	}
+
+	for _, tc := range tests {
+		policy, err := ParseSlowConsumerPolicy(tc.raw)
+		if tc.expectErr {
+			assert.Error(t, err, tc.raw)
+			continue
+		}
+		require.NoError(t, err, tc.raw)
+		assert.Equal(t, tc.expected, policy)
+	}
+}
+
+// blockingTransport is a Transport whose writes block until it is closed, so
+// messages sent after the first one stay in the send queue.
+type blockingTransport struct {
+	writing   chan []byte
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func newBlockingTransport() *blockingTransport {
+	return &blockingTransport{
+		writing:   make(chan []byte, 16),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+func (t *blockingTransport) Name() string       { return "blocking" }
+func (t *blockingTransport) Protocol() string   { return "" }
+func (t *blockingTransport) RemoteAddr() string { return "test" }
+
+func (t *blockingTransport) ReadMessage() ([]byte, error) {
+	<-t.closed
+	return nil, io.EOF
+}
+
+func (t *blockingTransport) SetReadDeadline(time.Time) error { return nil }
+
+func (t *blockingTransport) WriteMessage(msg signals.Message, data []byte, deadline time.Time) error {
+	t.writing <- data
+	<-t.closed
+	return net.ErrClosed
+}
+
+func (t *blockingTransport) Ping(time.Time) error                    { return nil }
+func (t *blockingTransport) SetPongHandler(func())                   {}
+func (t *blockingTransport) WriteClose(int, string, time.Time) error { return nil }
+
+func (t *blockingTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+// newQueuedClient returns a client whose writer is stuck writing the first
+// message, so later messages stay in the queue.
+func newQueuedClient(t *testing.T, policy SlowConsumerPolicy) *Client {
+	transport := newBlockingTransport()
+	c := New(zap.NewNop(), transport, Options{QueueSize: 2, SlowConsumer: policy}, "test", RoleSubscriber, nil)
+	t.Cleanup(func() {
+		c.Terminate()
+		c.Close()
+	})
+
+	require.NoError(t, c.Send(signals.Message{Signal: signals.SignalPing}))
+	<-transport.writing
+	return c
+}
+
+func (c *Client) queued() []string {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	result := []string{}
+	for msg, ok := c.outbox.Pop(); ok; msg, ok = c.outbox.Pop() {
+		result = append(result, string(msg.data))
+	}
+	return result
+}
+
+func TestSlowConsumerPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		policy    SlowConsumerPolicy
+		expected  []string
+		dropped   uint64
+		stopped   bool
+		expectErr error
+	}{
+		{policy: DropOldest, expected: []string{"b", "c"}, dropped: 1, stopped: false, expectErr: nil},
+		{policy: DropNewest, expected: []string{"a", "b"}, dropped: 1, stopped: false, expectErr: ErrSlowConsumer},
+		{policy: Disconnect, expected: []string{"a", "b"}, dropped: 0, stopped: true, expectErr: ErrSlowConsumer},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(string(tc.policy), func(t *testing.T) {
+			t.Parallel()
+
+			c := newQueuedClient(t, tc.policy)
+			require.NoError(t, c.enqueue(outbound{data: []byte("a")}))
+			require.NoError(t, c.enqueue(outbound{data: []byte("b")}))
+
+			err := c.enqueue(outbound{data: []byte("c")})
+			if tc.expectErr != nil {
+				assert.ErrorIs(t, err, tc.expectErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, c.queued())
+			assert.Equal(t, tc.dropped, c.Dropped())
+			assert.Equal(t, tc.stopped, c.stopped())
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	c := newQueuedClient(t, Disconnect)
+	require.NoError(t, c.enqueue(outbound{data: []byte("a")}))
+
+	missed := []signals.Message{{Signal: signals.SignalOn}, {Signal: signals.SignalOff}, {Signal: signals.SignalOn}, {Signal: signals.SignalOff}}
+	require.NoError(t, c.Replay(missed))
+	assert.False(t, c.stopped())
+	assert.Equal(t, uint64(0), c.Dropped())
+
+	c.outMu.Lock()
+	replayed := []signals.Message{}
+	for msg, ok := c.backlog.Pop(); ok; msg, ok = c.backlog.Pop() {
+		replayed = append(replayed, msg.msg)
+	}
+	c.outMu.Unlock()
+	assert.Equal(t, missed, replayed)
+	assert.Equal(t, []string{"a"}, c.queued())
+
+	c.Shutdown(websocket.CloseNormalClosure, "")
+	assert.ErrorIs(t, c.Replay(missed), ErrClosed)
+}
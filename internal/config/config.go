@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/serg-pe/signals/pkg/logger"
@@ -10,11 +11,182 @@ import (
 type AppConfig struct {
 	logger.LoggerConfig `toml:"logger"`
 	ServerConfig        `toml:"server"`
+	AuthConfig          `toml:"auth"`
 }
 
 type ServerConfig struct {
 	Ip   string `toml:"ip"`
 	Port uint16 `toml:"port"`
+
+	// TCPPort and UDPPort serve devices without a websocket stack, zero disables them.
+	TCPPort uint16 `toml:"tcp_port"`
+	UDPPort uint16 `toml:"udp_port"`
+	// UDPSessionTimeout ends UDP sessions that send nothing for that long.
+	UDPSessionTimeout time.Duration `toml:"udp_session_timeout"`
+	// UDPMaxSessions bounds the concurrent UDP sessions, hellos from new senders beyond it are dropped.
+	UDPMaxSessions int `toml:"udp_max_sessions"`
+
+	// AllowedOrigins lists browser origins allowed to connect, e.g. "https://*.example.com".
+	// Empty allows only the server host, "*" allows any origin.
+	AllowedOrigins []string `toml:"allowed_origins"`
+
+	// PingInterval is the period of websocket pings, a client that does not
+	// answer within PongTimeout is disconnected. Zero disables pings.
+	PingInterval time.Duration `toml:"ping_interval"`
+	PongTimeout  time.Duration `toml:"pong_timeout"`
+	// IdleTimeout disconnects clients that send no messages for that long. Zero disables it.
+	IdleTimeout time.Duration `toml:"idle_timeout"`
+
+	// SendQueueSize bounds the messages waiting to be written to a client.
+	SendQueueSize int `toml:"send_queue_size"`
+	// SlowConsumerPolicy applies when the send queue is full: "drop_oldest", "drop_newest" or "disconnect".
+	SlowConsumerPolicy string        `toml:"slow_consumer_policy"`
+	WriteTimeout       time.Duration `toml:"write_timeout"`
+
+	// DrainTimeout is how long a shutdown waits for clients to answer the close frame.
+	DrainTimeout time.Duration `toml:"drain_timeout"`
+
+	// TLSCert and TLSKey enable wss://, both files are reloaded when they change.
+	TLSCert string `toml:"tls_cert"`
+	TLSKey  string `toml:"tls_key"`
+	// ClientCA verifies client certificates for mutual TLS.
+	ClientCA          string        `toml:"client_ca"`
+	TLSReloadInterval time.Duration `toml:"tls_reload_interval"`
+
+	// RetainLastSignal keeps the last state signal of a channel and replays it to new subscribers.
+	RetainLastSignal bool                     `toml:"retain_last_signal"`
+	Channels         map[string]ChannelConfig `toml:"channels,omitempty"`
+
+	MQTT     MQTTConfig     `toml:"mqtt"`
+	Webhooks WebhooksConfig `toml:"webhooks"`
+	History  HistoryConfig  `toml:"history"`
+}
+
+// HistoryConfig keeps the state signals of every channel on disk.
+type HistoryConfig struct {
+	// Dir holds the segment files of every channel, empty disables the history.
+	Dir string `toml:"dir"`
+	// SegmentSize is the size in bytes after which a new segment file is started.
+	SegmentSize int64 `toml:"segment_size"`
+	// MaxAge removes segments whose newest record is older, zero keeps them forever.
+	MaxAge time.Duration `toml:"max_age"`
+	// MaxSize bounds the size in bytes of the history of a channel, zero does not.
+	MaxSize int64 `toml:"max_size"`
+	// ReplayLimit bounds the signals replayed to a reconnecting subscriber.
+	ReplayLimit int `toml:"replay_limit"`
+}
+
+// WebhooksConfig posts state signals and publisher presence changes to http endpoints.
+type WebhooksConfig struct {
+	// Timeout limits a single delivery attempt.
+	Timeout time.Duration `toml:"timeout"`
+	// MaxAttempts is how often a delivery is tried before it is given up.
+	MaxAttempts int `toml:"max_attempts"`
+	// RetryDelay is the delay after the first failed attempt, it doubles up to MaxRetryDelay.
+	RetryDelay    time.Duration `toml:"retry_delay"`
+	MaxRetryDelay time.Duration `toml:"max_retry_delay"`
+	// QueueSize bounds the events waiting for delivery per hook, the oldest are dropped.
+	QueueSize int             `toml:"queue_size"`
+	Hooks     []WebhookConfig `toml:"hooks,omitempty"`
+}
+
+type WebhookConfig struct {
+	URL string `toml:"url"`
+	// Secret signs the body with HMAC-SHA256, empty sends no signature.
+	Secret string `toml:"secret,omitempty"`
+	// Channels limits the hook to channel names, a trailing "*" matches by prefix. Empty allows all.
+	Channels []string `toml:"channels,omitempty"`
+	// Events limits the hook to "on", "off", "publisher-connected" and "publisher-disconnected". Empty allows all.
+	Events []string `toml:"events,omitempty"`
+}
+
+// MQTTConfig bridges channels to the topics of an MQTT broker.
+type MQTTConfig struct {
+	// Broker is the host:port of the broker, empty disables the bridge.
+	Broker   string `toml:"broker"`
+	ClientID string `toml:"client_id"`
+	Username string `toml:"username,omitempty"`
+	Password string `toml:"password,omitempty"`
+	// KeepAlive is the MQTT keep-alive interval.
+	KeepAlive time.Duration `toml:"keep_alive"`
+	// ReconnectDelay is the first delay before reconnecting, it doubles up to a minute.
+	ReconnectDelay time.Duration     `toml:"reconnect_delay"`
+	Topics         []MQTTTopicConfig `toml:"topics,omitempty"`
+}
+
+// MQTTTopicConfig maps a channel to topics, empty topics disable that direction.
+type MQTTTopicConfig struct {
+	Channel string `toml:"channel"`
+	// Publish receives every state signal of the channel.
+	Publish string `toml:"publish,omitempty"`
+	// Subscribe is a topic filter, its messages are published to the channel.
+	Subscribe string `toml:"subscribe,omitempty"`
+	// Retain asks the broker to keep the last message on the Publish topic.
+	Retain bool `toml:"retain,omitempty"`
+}
+
+// ChannelConfig overrides server settings for a single channel.
+type ChannelConfig struct {
+	RetainLastSignal *bool `toml:"retain_last_signal,omitempty"`
+}
+
+type AuthConfig struct {
+	// Enabled rejects connections without a valid token.
+	Enabled bool          `toml:"enabled"`
+	Tokens  []TokenConfig `toml:"tokens,omitempty"`
+	JWT     JWTConfig     `toml:"jwt"`
+	// Certificates grant permissions to TLS client certificates verified by ClientCA.
+	Certificates []CertificateConfig `toml:"certificates,omitempty"`
+	// Admin tokens grant access to the admin API, which is disabled without them.
+	Admin []AdminTokenConfig `toml:"admin,omitempty"`
+}
+
+type AdminTokenConfig struct {
+	// Name identifies the operator in logs.
+	Name  string `toml:"name,omitempty"`
+	Token string `toml:"token"`
+}
+
+type TokenConfig struct {
+	// Name identifies the token holder in logs.
+	Name  string `toml:"name,omitempty"`
+	Token string `toml:"token"`
+	// Role is one of "publish", "subscribe" or "both".
+	Role string `toml:"role"`
+	// Channels limits the token to channel names, a trailing "*" matches by prefix. Empty allows all.
+	Channels []string `toml:"channels,omitempty"`
+}
+
+type CertificateConfig struct {
+	// Name matches the certificate common name or one of its subject alternative names.
+	Name string `toml:"name"`
+	// Role is one of "publish", "subscribe" or "both".
+	Role string `toml:"role"`
+	// Channels limits the certificate to channel names, a trailing "*" matches by prefix. Empty allows all.
+	Channels []string `toml:"channels,omitempty"`
+}
+
+// JWTConfig accepts JSON Web Tokens signed with HS256, RS256 or EdDSA keys.
+type JWTConfig struct {
+	// JWKSFile is a JSON Web Key Set, empty disables JWT authentication.
+	JWKSFile string `toml:"jwks_file"`
+	Issuer   string `toml:"issuer,omitempty"`
+	Audience string `toml:"audience,omitempty"`
+	// RoleClaim holds "publish", "subscribe" or "both".
+	RoleClaim string `toml:"role_claim"`
+	// ChannelsClaim holds a channel name or a list of them, a trailing "*" matches by prefix.
+	ChannelsClaim string `toml:"channels_claim"`
+	// ReloadInterval is how often JWKSFile is checked for changes.
+	ReloadInterval time.Duration `toml:"reload_interval"`
+	// AllowNoExpiry accepts tokens without an "exp" claim, which never expire.
+	AllowNoExpiry bool `toml:"allow_no_expiry,omitempty"`
+}
+
+func (c ServerConfig) RetainLastSignalFor(channel string) bool {
+	if chCfg, ok := c.Channels[channel]; ok && chCfg.RetainLastSignal != nil {
+		return *chCfg.RetainLastSignal
+	}
+	return c.RetainLastSignal
 }
 
 func NewFromFile(path string) (AppConfig, error) {
@@ -35,6 +207,47 @@ func NewBaseConfigFile(path string) error {
 		ServerConfig: ServerConfig{
 			Ip:   "127.0.0.1",
 			Port: 8000,
+
+			UDPSessionTimeout: time.Minute,
+			UDPMaxSessions:    1024,
+
+			RetainLastSignal: true,
+
+			PingInterval: time.Second * 20,
+			PongTimeout:  time.Second * 10,
+
+			SendQueueSize:      64,
+			SlowConsumerPolicy: "drop_oldest",
+			WriteTimeout:       time.Second * 10,
+
+			DrainTimeout: time.Second * 5,
+
+			TLSReloadInterval: time.Second * 10,
+
+			MQTT: MQTTConfig{
+				ClientID:       "signals",
+				KeepAlive:      time.Second * 30,
+				ReconnectDelay: time.Second,
+			},
+			Webhooks: WebhooksConfig{
+				Timeout:       time.Second * 5,
+				MaxAttempts:   5,
+				RetryDelay:    time.Second,
+				MaxRetryDelay: time.Minute,
+				QueueSize:     256,
+			},
+			History: HistoryConfig{
+				SegmentSize: 1 << 20,
+				MaxAge:      time.Hour * 24 * 30,
+				ReplayLimit: 1000,
+			},
+		},
+		AuthConfig: AuthConfig{
+			JWT: JWTConfig{
+				RoleClaim:      "role",
+				ChannelsClaim:  "channels",
+				ReloadInterval: time.Second * 10,
+			},
 		},
 	})
 	return nil
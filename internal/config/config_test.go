@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetainLastSignalFor(t *testing.T) {
+	t.Parallel()
+
+	retain, forget := true, false
+	tests := []struct {
+		name     string
+		cfg      ServerConfig
+		channel  string
+		expected bool
+	}{
+		{name: "server default", cfg: ServerConfig{RetainLastSignal: true}, channel: "kitchen", expected: true},
+		{name: "disabled", cfg: ServerConfig{}, channel: "kitchen", expected: false},
+		{name: "channel without override", cfg: ServerConfig{RetainLastSignal: true, Channels: map[string]ChannelConfig{"kitchen": {}}}, channel: "kitchen", expected: true},
+		{name: "channel disables", cfg: ServerConfig{RetainLastSignal: true, Channels: map[string]ChannelConfig{"kitchen": {RetainLastSignal: &forget}}}, channel: "kitchen", expected: false},
+		{name: "channel enables", cfg: ServerConfig{Channels: map[string]ChannelConfig{"kitchen": {RetainLastSignal: &retain}}}, channel: "kitchen", expected: true},
+		{name: "other channel", cfg: ServerConfig{Channels: map[string]ChannelConfig{"kitchen": {RetainLastSignal: &retain}}}, channel: "hall", expected: false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, tc.cfg.RetainLastSignalFor(tc.channel), tc.name)
+	}
+}
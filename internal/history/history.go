@@ -0,0 +1,501 @@
+// Package history keeps an append-only log of the state signals of every
+// channel on disk.
+//
+// Every channel has a directory of segment files. A segment is named after
+// the sequence number of its first record and holds one JSON record per line.
+// Records are appended to the newest segment until it grows past the segment
+// size; retention removes whole segments, never the newest one.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	segmentExt = ".log"
+
+	defaultSegmentSize       = 1 << 20
+	defaultRetentionInterval = time.Minute
+)
+
+// Record is a state signal published to a channel.
+type Record struct {
+	Time      time.Time      `json:"time"`
+	Seq       uint32         `json:"seq"`
+	Signal    signals.Signal `json:"signal"`
+	Publisher string         `json:"publisher,omitempty"`
+}
+
+type Options struct {
+	// Dir holds a directory per channel.
+	Dir string
+	// SegmentSize is the size in bytes after which a new segment is started.
+	SegmentSize int64
+	// MaxAge removes segments whose newest record is older, zero keeps them.
+	MaxAge time.Duration
+	// MaxSize bounds the size in bytes of the segments of a channel, zero does not.
+	MaxSize int64
+}
+
+// Store appends records and reads them back. It is safe for concurrent use,
+// reads do not wait for appends to the same channel.
+type Store struct {
+	opts   Options
+	logger *zap.Logger
+
+	mu   *sync.Mutex
+	logs map[string]*channelLog
+}
+
+// channelLog is the log of a single channel.
+type channelLog struct {
+	dir string
+
+	mu *sync.Mutex
+	// segments are the first sequence numbers of the segments, oldest first.
+	segments []uint32
+	last     Record
+	hasLast  bool
+	// active is the newest segment, opened on the first append.
+	active     *os.File
+	activeSize int64
+}
+
+func Open(opts Options, logger *zap.Logger) (*Store, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create history dir: %w", err)
+	}
+
+	return &Store{
+		opts:   opts,
+		logger: logger,
+		mu:     &sync.Mutex{},
+		logs:   make(map[string]*channelLog),
+	}, nil
+}
+
+// Last returns the newest record of the channel. It reports false if the channel has no records.
+func (s *Store) Last(channel string) (Record, bool, error) {
+	log, err := s.log(channel, false)
+	if err != nil || log == nil {
+		return Record{}, false, err
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	return log.last, log.hasLast, nil
+}
+
+// Cached returns the newest record of the channel like Last, but only if its
+// log is already open, so it never touches the disk. It reports false otherwise.
+func (s *Store) Cached(channel string) (Record, bool) {
+	s.mu.Lock()
+	log, ok := s.logs[channel]
+	s.mu.Unlock()
+	if !ok {
+		return Record{}, false
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	return log.last, log.hasLast
+}
+
+// Append writes the record to the newest segment of the channel. Records must
+// be appended in the order of their sequence numbers.
+func (s *Store) Append(channel string, record Record) error {
+	log, err := s.log(channel, true)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	if log.active == nil || log.activeSize >= s.opts.SegmentSize {
+		if err := log.rotate(record.Seq, s.opts.SegmentSize); err != nil {
+			return err
+		}
+	}
+
+	n, err := log.active.Write(line)
+	log.activeSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("append record: %w", err)
+	}
+	log.last, log.hasLast = record, true
+	return nil
+}
+
+// After returns up to limit records of the channel with a sequence number above seq, oldest first.
+func (s *Store) After(channel string, seq uint32, limit int) ([]Record, error) {
+	log, err := s.log(channel, false)
+	if err != nil || log == nil {
+		return nil, err
+	}
+
+	segments := log.snapshot()
+	// Segments that end before seq are skipped, the one containing it is the last with a lower first seq.
+	start := sort.Search(len(segments), func(i int) bool { return segments[i] > seq })
+	if start > 0 {
+		start--
+	}
+	return log.read(segments[start:], limit, func(r Record) bool { return r.Seq > seq })
+}
+
+// Range returns the newest limit records of the channel published in [from,
+// to), oldest first, zero limit returns them all. Zero times leave that end
+// open. Older records are paged through with to set to the time of the first
+// record returned.
+func (s *Store) Range(channel string, from, to time.Time, limit int) ([]Record, error) {
+	log, err := s.log(channel, false)
+	if err != nil || log == nil {
+		return nil, err
+	}
+
+	segments := log.snapshot()
+	if !from.IsZero() {
+		// A segment last written before from holds nothing newer.
+		for len(segments) > 1 {
+			info, err := os.Stat(log.segmentPath(segments[0]))
+			if err != nil || !info.ModTime().Before(from) {
+				break
+			}
+			segments = segments[1:]
+		}
+	}
+
+	match := func(r Record) bool {
+		return (from.IsZero() || !r.Time.Before(from)) && (to.IsZero() || r.Time.Before(to))
+	}
+	// Segments are read newest first until they hold enough records.
+	var result []Record
+	for i := len(segments) - 1; i >= 0; i-- {
+		records, err := log.read(segments[i:i+1], 0, match)
+		if err != nil {
+			return nil, err
+		}
+		result = append(records, result...)
+		if limit > 0 && len(result) >= limit {
+			return result[len(result)-limit:], nil
+		}
+	}
+	return result, nil
+}
+
+// Run applies the retention to every channel periodically until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+	s.enforceRetention()
+
+	ticker := time.NewTicker(defaultRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.enforceRetention()
+		}
+	}
+}
+
+func (s *Store) enforceRetention() {
+	if s.opts.MaxAge <= 0 && s.opts.MaxSize <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		s.logger.Error("list history", zap.Error(err))
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		channel, err := unescapeChannel(entry.Name())
+		if err != nil {
+			continue
+		}
+		log, err := s.log(channel, false)
+		if err != nil {
+			s.logger.Error("open channel history", zap.String("channel", channel), zap.Error(err))
+			continue
+		}
+		if log == nil {
+			continue
+		}
+		removed, err := log.retain(s.opts.MaxAge, s.opts.MaxSize)
+		if err != nil {
+			s.logger.Error("apply history retention", zap.String("channel", channel), zap.Error(err))
+		}
+		if removed > 0 {
+			s.logger.Debug("history segments removed", zap.String("channel", channel), zap.Int("segments", removed))
+		}
+	}
+}
+
+// Close closes the active segments.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, log := range s.logs {
+		log.mu.Lock()
+		if log.active != nil {
+			errs = append(errs, log.active.Close())
+			log.active = nil
+		}
+		log.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// log returns the log of the channel, reading its segments on first use
+// without holding the lock. Without create it returns nil for channels that
+// have no history, so queries for made up channels leave no trace.
+func (s *Store) log(channel string, create bool) (*channelLog, error) {
+	s.mu.Lock()
+	log, ok := s.logs[channel]
+	s.mu.Unlock()
+	if ok {
+		return log, nil
+	}
+
+	dir := filepath.Join(s.opts.Dir, escapeChannel(channel))
+	if create {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create channel history dir: %w", err)
+		}
+	}
+	log, err := openChannelLog(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another caller may have opened the log meanwhile, nothing is written before it is stored.
+	if opened, ok := s.logs[channel]; ok {
+		return opened, nil
+	}
+	s.logs[channel] = log
+	return log, nil
+}
+
+func openChannelLog(dir string) (*channelLog, error) {
+	log := &channelLog{dir: dir, mu: &sync.Mutex{}}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		raw, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		first, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			continue
+		}
+		log.segments = append(log.segments, uint32(first))
+	}
+	sort.Slice(log.segments, func(i, j int) bool { return log.segments[i] < log.segments[j] })
+
+	if len(log.segments) > 0 {
+		records, err := log.read(log.segments[len(log.segments)-1:], 0, func(Record) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			log.last, log.hasLast = records[len(records)-1], true
+		}
+	}
+	return log, nil
+}
+
+// rotate starts appending to the segment named after first. The newest
+// segment is reused after a restart while it has room, the caller must hold mu.
+func (l *channelLog) rotate(first uint32, segmentSize int64) error {
+	if l.active == nil && len(l.segments) > 0 {
+		newest := l.segments[len(l.segments)-1]
+		info, err := os.Stat(l.segmentPath(newest))
+		if err == nil && info.Size() < segmentSize {
+			return l.openActive(newest, info.Size())
+		}
+	}
+
+	if l.active != nil {
+		if err := l.active.Close(); err != nil {
+			return fmt.Errorf("close segment: %w", err)
+		}
+		l.active = nil
+	}
+	if err := l.openActive(first, 0); err != nil {
+		return err
+	}
+	l.segments = append(l.segments, first)
+	return nil
+}
+
+func (l *channelLog) openActive(first uint32, size int64) error {
+	file, err := os.OpenFile(l.segmentPath(first), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open segment: %w", err)
+	}
+
+	// A crash may have left a torn line, the next record must start on a line of its own.
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err == nil && last[0] != '\n' {
+			n, err := file.Write([]byte{'\n'})
+			size += int64(n)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("terminate torn record: %w", err)
+			}
+		}
+	}
+
+	l.active, l.activeSize = file, size
+	return nil
+}
+
+// snapshot returns the segments to read without holding mu. A segment removed
+// by the retention meanwhile is skipped and a record being appended is torn, so
+// reads see every record appended before they started.
+func (l *channelLog) snapshot() []uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]uint32(nil), l.segments...)
+}
+
+// read returns up to limit records of the segments that match, zero limit
+// returns them all.
+func (l *channelLog) read(segments []uint32, limit int, match func(Record) bool) ([]Record, error) {
+	var result []Record
+	for _, first := range segments {
+		file, err := os.Open(l.segmentPath(first))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadBytes('\n')
+			// A torn line at the end of a segment was never acknowledged, skip it.
+			if len(bytes.TrimSpace(line)) > 0 && err == nil {
+				record := Record{}
+				if jsonErr := json.Unmarshal(line, &record); jsonErr == nil && match(record) {
+					result = append(result, record)
+					if limit > 0 && len(result) == limit {
+						file.Close()
+						return result, nil
+					}
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return result, err
+			}
+		}
+		file.Close()
+	}
+	return result, nil
+}
+
+// retain removes the oldest segments that are older than maxAge or exceed
+// maxSize together, the newest segment is always kept.
+func (l *channelLog) retain(maxAge time.Duration, maxSize int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.segments) < 2 {
+		return 0, nil
+	}
+
+	sizes := make([]int64, len(l.segments))
+	modTimes := make([]time.Time, len(l.segments))
+	var total int64
+	for i, first := range l.segments {
+		info, err := os.Stat(l.segmentPath(first))
+		if err != nil {
+			return 0, err
+		}
+		sizes[i], modTimes[i] = info.Size(), info.ModTime()
+		total += info.Size()
+	}
+
+	removed := 0
+	for removed < len(l.segments)-1 {
+		expired := maxAge > 0 && time.Since(modTimes[removed]) > maxAge
+		oversized := maxSize > 0 && total > maxSize
+		if !expired && !oversized {
+			break
+		}
+		if err := os.Remove(l.segmentPath(l.segments[removed])); err != nil {
+			l.segments = l.segments[removed:]
+			return removed, err
+		}
+		total -= sizes[removed]
+		removed++
+	}
+	l.segments = l.segments[removed:]
+	return removed, nil
+}
+
+func (l *channelLog) segmentPath(first uint32) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%010d%s", first, segmentExt))
+}
+
+// escapeChannel turns a channel name into a directory name. Dots are escaped
+// as well, so names like ".." stay inside the history directory.
+func escapeChannel(channel string) string {
+	return strings.ReplaceAll(url.PathEscape(channel), ".", "%2E")
+}
+
+func unescapeChannel(name string) (string, error) {
+	return url.PathUnescape(name)
+}
@@ -0,0 +1,203 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// appendRecords appends records with sequence numbers from first to last, a minute apart.
+func appendRecords(t *testing.T, s *Store, channel string, start time.Time, first, last uint32) {
+	for seq := first; seq <= last; seq++ {
+		require.NoError(t, s.Append(channel, Record{
+			Time:      start.Add(time.Duration(seq) * time.Minute),
+			Seq:       seq,
+			Signal:    signals.SignalOn,
+			Publisher: "press",
+		}))
+	}
+}
+
+func seqs(records []Record) []uint32 {
+	result := make([]uint32, 0, len(records))
+	for _, r := range records {
+		result = append(result, r.Seq)
+	}
+	return result
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// Every segment holds two records.
+	opts := Options{Dir: dir, SegmentSize: 100}
+	s, err := Open(opts, zap.NewNop())
+	require.NoError(t, err)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	appendRecords(t, s, "kitchen", start, 1, 5)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "kitchen"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	records, err := s.After("kitchen", 2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{3, 4, 5}, seqs(records))
+	assert.Equal(t, "press", records[0].Publisher)
+
+	records, err = s.After("kitchen", 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, seqs(records))
+
+	records, err = s.Range("kitchen", start.Add(time.Minute*2), start.Add(time.Minute*4), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2, 3}, seqs(records))
+
+	// A range returns its newest records, older ones are paged through with to.
+	records, err = s.Range("kitchen", time.Time{}, time.Time{}, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{3, 4, 5}, seqs(records))
+	records, err = s.Range("kitchen", time.Time{}, records[0].Time, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, seqs(records))
+
+	records, err = s.After("unknown", 0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	assert.NoDirExists(t, filepath.Join(dir, "unknown"))
+
+	// The log continues after a restart.
+	require.NoError(t, s.Close())
+	s, err = Open(opts, zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+
+	last, ok, err := s.Last("kitchen")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint32(5), last.Seq)
+	assert.Equal(t, start.Add(time.Minute*5), last.Time)
+	appendRecords(t, s, "kitchen", start, 6, 6)
+	records, err = s.After("kitchen", 4, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{5, 6}, seqs(records))
+}
+
+func TestTornRecord(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir}, zap.NewNop())
+	require.NoError(t, err)
+	appendRecords(t, s, "kitchen", time.Now(), 1, 1)
+	require.NoError(t, s.Close())
+
+	segment := filepath.Join(dir, "kitchen", "0000000001.log")
+	file, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"time":"2024-05-01T12:00:00Z","se`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	s, err = Open(Options{Dir: dir}, zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+	appendRecords(t, s, "kitchen", time.Now(), 2, 2)
+
+	records, err := s.After("kitchen", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2}, seqs(records))
+}
+
+func TestConcurrentReads(t *testing.T) {
+	t.Parallel()
+
+	s, err := Open(Options{Dir: t.TempDir(), SegmentSize: 100}, zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for seq := uint32(1); seq <= 50; seq++ {
+			assert.NoError(t, s.Append("kitchen", Record{Time: time.Now(), Seq: seq, Signal: signals.SignalOn}))
+		}
+	}()
+
+	// Reads racing the appends see a gapless prefix of the records.
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		records, err := s.After("kitchen", 0, 0)
+		require.NoError(t, err)
+		for i, record := range records {
+			require.Equal(t, uint32(i+1), record.Seq)
+		}
+	}
+
+	records, err := s.After("kitchen", 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, records, 50)
+}
+
+func TestRetention(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		maxSize  int64
+		expected []uint32
+	}{
+		{name: "keep", expected: []uint32{1, 2, 3, 4, 5}},
+		{name: "size", maxSize: 300, expected: []uint32{3, 4, 5}},
+		{name: "age", maxAge: time.Hour, expected: []uint32{5}},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s, err := Open(Options{Dir: dir, SegmentSize: 100, MaxAge: tc.maxAge, MaxSize: tc.maxSize}, zap.NewNop())
+			require.NoError(t, err)
+			defer s.Close()
+			appendRecords(t, s, "kitchen", time.Now(), 1, 5)
+
+			// Every segment but the newest one is old.
+			old := time.Now().Add(-time.Hour * 2)
+			for _, first := range []string{"0000000001.log", "0000000003.log"} {
+				require.NoError(t, os.Chtimes(filepath.Join(dir, "kitchen", first), old, old))
+			}
+
+			s.enforceRetention()
+			records, err := s.After("kitchen", 0, 0)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, seqs(records))
+		})
+	}
+}
+
+func TestEscapeChannel(t *testing.T) {
+	t.Parallel()
+
+	for _, channel := range []string{"kitchen", "..", "floor/1", "a b.c"} {
+		escaped := escapeChannel(channel)
+		assert.NotContains(t, escaped, "/")
+		assert.NotContains(t, escaped, ".")
+		unescaped, err := unescapeChannel(escaped)
+		require.NoError(t, err)
+		assert.Equal(t, channel, unescaped)
+	}
+}
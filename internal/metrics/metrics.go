@@ -0,0 +1,310 @@
+// Package metrics implements counters, gauges and histograms exposed in the
+// Prometheus text format, without depending on the Prometheus client.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// collector writes the samples of one metric family.
+type collector interface {
+	describe() (name, help, kind string)
+	collect(w *bufio.Writer)
+}
+
+// Registry holds metric families and serves them over http.
+type Registry struct {
+	mu         *sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{mu: &sync.Mutex{}}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Counter registers a counter without labels.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(c)
+	return c
+}
+
+// CounterVec registers a counter partitioned by the label names.
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		mu:     &sync.RWMutex{},
+		values: make(map[string]*labeledCounter),
+	}
+	r.register(c)
+	return c
+}
+
+// GaugeFunc registers a gauge partitioned by the label names. collect is called
+// on every scrape and reports the current values with emit.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	r.register(&gaugeFunc{name: name, help: help, labels: labels, fn: collect})
+}
+
+// Histogram registers a histogram with the upper bounds of its buckets in ascending order.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		mu:      &sync.Mutex{},
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	r.register(h)
+	return h
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.WriteTo(w)
+}
+
+// WriteTo writes every registered metric in the text exposition format.
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: out}
+	w := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		name, help, kind := c.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		c.collect(w)
+	}
+	err := w.Flush()
+	return counter.n, err
+}
+
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) describe() (string, string, string) {
+	return c.name, c.help, "counter"
+}
+
+func (c *Counter) collect(w *bufio.Writer) {
+	writeSample(w, c.name, nil, nil, float64(c.Value()))
+}
+
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     *sync.RWMutex
+	values map[string]*labeledCounter
+}
+
+type labeledCounter struct {
+	labelValues []string
+	value       atomic.Uint64
+}
+
+// Inc increments the counter with the label values, given in the order of the label names.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.with(labelValues).value.Add(1)
+}
+
+func (c *CounterVec) Value(labelValues ...string) uint64 {
+	return c.with(labelValues).value.Load()
+}
+
+func (c *CounterVec) with(labelValues []string) *labeledCounter {
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.RLock()
+	counter, ok := c.values[key]
+	c.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok = c.values[key]; !ok {
+		counter = &labeledCounter{labelValues: labelValues}
+		c.values[key] = counter
+	}
+	return counter
+}
+
+func (c *CounterVec) describe() (string, string, string) {
+	return c.name, c.help, "counter"
+}
+
+func (c *CounterVec) collect(w *bufio.Writer) {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	counters := make([]*labeledCounter, 0, len(keys))
+	for _, key := range keys {
+		counters = append(counters, c.values[key])
+	}
+	c.mu.RUnlock()
+
+	for _, counter := range counters {
+		writeSample(w, c.name, c.labels, counter.labelValues, float64(counter.value.Load()))
+	}
+}
+
+type gaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func(emit func(value float64, labelValues ...string))
+}
+
+func (g *gaugeFunc) describe() (string, string, string) {
+	return g.name, g.help, "gauge"
+}
+
+func (g *gaugeFunc) collect(w *bufio.Writer) {
+	g.fn(func(value float64, labelValues ...string) {
+		writeSample(w, g.name, g.labels, labelValues, value)
+	})
+}
+
+type Histogram struct {
+	name string
+	help string
+
+	mu      *sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *Histogram) describe() (string, string, string) {
+	return h.name, h.help, "histogram"
+}
+
+func (h *Histogram) collect(w *bufio.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		writeSample(w, h.name+"_bucket", []string{"le"}, []string{formatFloat(bound)}, float64(counts[i]))
+	}
+	writeSample(w, h.name+"_bucket", []string{"le"}, []string{"+Inf"}, float64(count))
+	writeSample(w, h.name+"_sum", nil, nil, sum)
+	writeSample(w, h.name+"_count", nil, nil, float64(count))
+}
+
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			labelValue := ""
+			if i < len(labelValues) {
+				labelValue = labelValues[i]
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escapeLabel(labelValue))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+
+	dropped := r.Counter("test_dropped_total", "Dropped messages.")
+	dropped.Add(3)
+
+	received := r.CounterVec("test_received_total", "Received signals.", "signal")
+	received.Inc("on")
+	received.Inc("on")
+	received.Inc(`o"ff`)
+
+	r.GaugeFunc("test_subscribers", "Subscribers\nper channel.", []string{"channel"}, func(emit func(float64, ...string)) {
+		emit(2, "kitchen")
+	})
+
+	latency := r.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+
+	out := &strings.Builder{}
+	_, err := r.WriteTo(out)
+	require.NoError(t, err)
+
+	expected := `# HELP test_dropped_total Dropped messages.
+# TYPE test_dropped_total counter
+test_dropped_total 3
+# HELP test_received_total Received signals.
+# TYPE test_received_total counter
+test_received_total{signal="o\"ff"} 1
+test_received_total{signal="on"} 2
+# HELP test_subscribers Subscribers\nper channel.
+# TYPE test_subscribers gauge
+test_subscribers{channel="kitchen"} 2
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 5.55
+test_latency_seconds_count 3
+`
+	assert.Equal(t, expected, out.String())
+	assert.Equal(t, uint64(2), received.Value("on"))
+}
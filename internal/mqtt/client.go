@@ -0,0 +1,284 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/internal/backoff"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultKeepAlive         = time.Second * 30
+	defaultReconnectMinDelay = time.Second
+	defaultReconnectMaxDelay = time.Minute
+	defaultWriteTimeout      = time.Second * 10
+)
+
+var (
+	ErrNotConnected = errors.New("not connected to broker")
+	ErrRefused      = errors.New("connection refused by broker")
+)
+
+type Options struct {
+	// Address of the broker as host:port.
+	Address  string
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive is the keep-alive interval announced to the broker, the client
+	// pings at half of it.
+	KeepAlive time.Duration
+
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+	// WriteTimeout limits a single write to the broker.
+	WriteTimeout time.Duration
+}
+
+// Handler is called from the reading goroutine for every message on a
+// subscribed topic and must not block.
+type Handler func(topic string, payload []byte)
+
+// Client keeps a session with a broker until the context of Run is done,
+// reconnecting with exponential backoff. Subscriptions are renewed on every
+// connect, messages published while disconnected are lost like any QoS 0 message.
+type Client struct {
+	opts   Options
+	logger *zap.Logger
+
+	mu     *sync.Mutex
+	conn   net.Conn
+	subs   map[string]Handler
+	nextID uint16
+
+	writeMu *sync.Mutex
+}
+
+func New(opts Options, logger *zap.Logger) *Client {
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+	if opts.ReconnectMinDelay <= 0 {
+		opts.ReconnectMinDelay = defaultReconnectMinDelay
+	}
+	if opts.ReconnectMaxDelay <= 0 {
+		opts.ReconnectMaxDelay = defaultReconnectMaxDelay
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defaultWriteTimeout
+	}
+
+	return &Client{
+		opts:    opts,
+		logger:  logger,
+		mu:      &sync.Mutex{},
+		subs:    make(map[string]Handler),
+		writeMu: &sync.Mutex{},
+	}
+}
+
+// Subscribe registers the handler for a topic filter, it is subscribed on the
+// next connect or at once if the client is connected.
+func (c *Client) Subscribe(filter string, handler Handler) error {
+	c.mu.Lock()
+	c.subs[filter] = handler
+	conn := c.conn
+	id := c.packetID()
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return c.write(conn, SubscribePacket(id, filter))
+}
+
+// Publish sends a QoS 0 message, it fails with ErrNotConnected while the broker is unreachable.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return c.write(conn, PublishPacket(topic, payload, retain))
+}
+
+// Connected reports whether a session with the broker is established.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil
+}
+
+// Run connects to the broker and serves the session until ctx is done.
+func (c *Client) Run(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		connected, err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+
+		delay := backoff.Delay(attempt, c.opts.ReconnectMinDelay, c.opts.ReconnectMaxDelay)
+		c.logger.Warn("broker connection lost", zap.String("broker", c.opts.Address), zap.Duration("retry_in", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// session dials the broker and reads packets until the connection fails or
+// ctx is done, connected tells whether the broker accepted the session.
+func (c *Client) session(ctx context.Context) (connected bool, err error) {
+	dialer := net.Dialer{Timeout: c.opts.WriteTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.opts.Address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if err := c.connect(conn); err != nil {
+		return false, err
+	}
+	c.logger.Info("connected to broker", zap.String("broker", c.opts.Address))
+
+	c.mu.Lock()
+	c.conn = conn
+	filters := make([]string, 0, len(c.subs))
+	for filter := range c.subs {
+		filters = append(filters, filter)
+	}
+	id := c.packetID()
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+	}()
+
+	if len(filters) > 0 {
+		if err := c.write(conn, SubscribePacket(id, filters...)); err != nil {
+			return true, err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.keepAlive(ctx, conn, done)
+
+	return true, c.read(conn)
+}
+
+func (c *Client) connect(conn net.Conn) error {
+	keepAlive := uint16(min(c.opts.KeepAlive/time.Second, 0xffff))
+	if err := c.write(conn, ConnectPacket(c.opts.ClientID, c.opts.Username, c.opts.Password, keepAlive)); err != nil {
+		return fmt.Errorf("send connect: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(c.opts.WriteTimeout)); err != nil {
+		return err
+	}
+	p, err := ReadPacket(conn)
+	if err != nil {
+		return fmt.Errorf("read connack: %w", err)
+	}
+	if p.Type != Connack || len(p.Body) != 2 {
+		return fmt.Errorf("%w: expected connack", ErrMalformedPacket)
+	}
+	if code := p.Body[1]; code != ConnackAccepted {
+		return fmt.Errorf("%w: code %d", ErrRefused, code)
+	}
+	return nil
+}
+
+// read dispatches messages until the connection fails. The broker answers
+// pings, so silence for longer than the keep-alive means the connection is dead.
+func (c *Client) read(conn net.Conn) error {
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive)); err != nil {
+			return err
+		}
+		p, err := ReadPacket(conn)
+		if err != nil {
+			return err
+		}
+		if p.Type != Publish {
+			continue
+		}
+
+		topic, payload, err := ParsePublish(p)
+		if err != nil {
+			c.logger.Debug("parse publish", zap.Error(err))
+			continue
+		}
+		for _, handler := range c.handlers(topic) {
+			handler(topic, payload)
+		}
+	}
+}
+
+// keepAlive pings the broker and closes the connection once ctx is done.
+func (c *Client) keepAlive(ctx context.Context, conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.KeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			if err := c.write(conn, Packet{Type: Disconnect}); err != nil {
+				c.logger.Debug("send disconnect", zap.Error(err))
+			}
+			conn.Close()
+			return
+		case <-ticker.C:
+			if err := c.write(conn, Packet{Type: Pingreq}); err != nil {
+				c.logger.Debug("ping broker", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (c *Client) handlers(topic string) []Handler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var result []Handler
+	for filter, handler := range c.subs {
+		if MatchTopic(filter, topic) {
+			result = append(result, handler)
+		}
+	}
+	return result
+}
+
+// packetID returns the next non-zero packet id, the caller must hold mu.
+func (c *Client) packetID() uint16 {
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	return c.nextID
+}
+
+func (c *Client) write(conn net.Conn, p Packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout)); err != nil {
+		return err
+	}
+	return WritePacket(conn, p)
+}
@@ -0,0 +1,112 @@
+package mqtt_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serg-pe/signals/internal/mqtt"
+	"github.com/serg-pe/signals/internal/mqtt/mqtttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	payload := bytes.Repeat([]byte{1}, 300)
+	buf := &bytes.Buffer{}
+	require.NoError(t, mqtt.WritePacket(buf, mqtt.PublishPacket("plant/press", payload, true)))
+
+	p, err := mqtt.ReadPacket(buf)
+	require.NoError(t, err)
+	assert.Equal(t, mqtt.Publish, p.Type)
+	assert.Equal(t, byte(0x01), p.Flags)
+
+	topic, got, err := mqtt.ParsePublish(p)
+	require.NoError(t, err)
+	assert.Equal(t, "plant/press", topic)
+	assert.Equal(t, payload, got)
+}
+
+func TestMatchTopic(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		filter   string
+		topic    string
+		expected bool
+	}{
+		{filter: "plant/press", topic: "plant/press", expected: true},
+		{filter: "plant/press", topic: "plant/press/1", expected: false},
+		{filter: "plant/+", topic: "plant/press", expected: true},
+		{filter: "plant/+", topic: "plant/press/1", expected: false},
+		{filter: "plant/#", topic: "plant/press/1", expected: true},
+		{filter: "plant/#", topic: "plant", expected: true},
+		{filter: "#", topic: "plant/press", expected: true},
+		{filter: "plant/+/state", topic: "plant/press/set", expected: false},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.filter+" "+tc.topic, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, mqtt.MatchTopic(tc.filter, tc.topic))
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	broker, err := mqtttest.NewBroker()
+	require.NoError(t, err)
+	defer broker.Close()
+
+	c := mqtt.New(mqtt.Options{Address: broker.Addr, ClientID: "test", ReconnectMinDelay: time.Millisecond * 10}, zap.NewNop())
+	assert.ErrorIs(t, c.Publish("plant/press", []byte("on"), false), mqtt.ErrNotConnected)
+
+	received := make(chan string, 10)
+	require.NoError(t, c.Subscribe("plant/+/set", func(topic string, payload []byte) {
+		received <- topic + " " + string(payload)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	waitReceive := func() string {
+		select {
+		case msg := <-received:
+			return msg
+		case <-time.After(time.Second * 5):
+			t.Fatal("no message received")
+			return ""
+		}
+	}
+
+	require.Eventually(t, func() bool { return broker.Subscribed("plant/+/set") }, time.Second*5, time.Millisecond*10)
+	require.NoError(t, c.Publish("plant/press/set", []byte("on"), false))
+	assert.Equal(t, "plant/press/set on", waitReceive())
+
+	select {
+	case msg := <-broker.Published():
+		assert.Equal(t, mqtttest.Message{Topic: "plant/press/set", Payload: []byte("on")}, msg)
+	case <-time.After(time.Second * 5):
+		t.Fatal("nothing published")
+	}
+
+	// Subscriptions survive a lost connection. Messages sent before the
+	// client is back are lost, so publish until one arrives.
+	broker.Disconnect()
+	require.Eventually(t, func() bool {
+		broker.Publish("plant/oven/set", []byte("off"))
+		select {
+		case msg := <-received:
+			return msg == "plant/oven/set off"
+		case <-time.After(time.Millisecond * 50):
+			return false
+		}
+	}, time.Second*5, time.Millisecond*10)
+}
@@ -0,0 +1,186 @@
+// Package mqtttest provides an in-process MQTT broker for tests, like
+// net/http/httptest does for http servers.
+package mqtttest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/serg-pe/signals/internal/mqtt"
+)
+
+// Message is a message published to the broker.
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Broker accepts QoS 0 sessions on a loopback port and relays messages to
+// every matching subscription. It keeps no retained messages.
+type Broker struct {
+	// Addr is the host:port the broker listens on.
+	Addr string
+
+	listener net.Listener
+
+	mu        *sync.Mutex
+	sessions  map[net.Conn]*session
+	published chan Message
+	wg        *sync.WaitGroup
+}
+
+type session struct {
+	writeMu *sync.Mutex
+	filters []string
+}
+
+// NewBroker starts a broker, Close stops it. Published reports every message
+// clients publish, it holds up to 64 of them and drops the rest.
+func NewBroker() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Broker{
+		Addr:      listener.Addr().String(),
+		listener:  listener,
+		mu:        &sync.Mutex{},
+		sessions:  make(map[net.Conn]*session),
+		published: make(chan Message, 64),
+		wg:        &sync.WaitGroup{},
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// Published returns the messages clients publish.
+func (b *Broker) Published() <-chan Message {
+	return b.published
+}
+
+// Publish relays a message to the subscribers as if a client had published it.
+func (b *Broker) Publish(topic string, payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn, s := range b.sessions {
+		for _, filter := range s.filters {
+			if mqtt.MatchTopic(filter, topic) {
+				s.write(conn, mqtt.PublishPacket(topic, payload, false))
+				break
+			}
+		}
+	}
+}
+
+// Subscribed reports whether some client subscribed to the filter.
+func (b *Broker) Subscribed(filter string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range b.sessions {
+		for _, f := range s.filters {
+			if f == filter {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Disconnect drops every client connection, the broker keeps accepting new ones.
+func (b *Broker) Disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for conn := range b.sessions {
+		conn.Close()
+	}
+}
+
+// Close stops the broker and drops every client.
+func (b *Broker) Close() {
+	b.listener.Close()
+	b.Disconnect()
+	b.wg.Wait()
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			defer conn.Close()
+			b.serve(conn)
+		}()
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	p, err := mqtt.ReadPacket(conn)
+	if err != nil || p.Type != mqtt.Connect {
+		return
+	}
+	if _, err := mqtt.ParseConnect(p); err != nil {
+		return
+	}
+	s := &session{writeMu: &sync.Mutex{}}
+
+	b.mu.Lock()
+	b.sessions[conn] = s
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, conn)
+		b.mu.Unlock()
+	}()
+	s.write(conn, mqtt.ConnackPacket(mqtt.ConnackAccepted))
+
+	for {
+		p, err := mqtt.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p.Type {
+		case mqtt.Subscribe:
+			id, filters, err := mqtt.ParseSubscribe(p)
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			s.filters = append(s.filters, filters...)
+			b.mu.Unlock()
+			s.write(conn, mqtt.SubackPacket(id, len(filters)))
+		case mqtt.Publish:
+			topic, payload, err := mqtt.ParsePublish(p)
+			if err != nil {
+				return
+			}
+			select {
+			case b.published <- Message{Topic: topic, Payload: payload}:
+			default:
+			}
+			b.Publish(topic, payload)
+		case mqtt.Pingreq:
+			s.write(conn, mqtt.Packet{Type: mqtt.Pingresp})
+		case mqtt.Disconnect:
+			return
+		}
+	}
+}
+
+func (s *session) write(conn net.Conn, p mqtt.Packet) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	mqtt.WritePacket(conn, p)
+}
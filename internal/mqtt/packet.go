@@ -0,0 +1,281 @@
+// Package mqtt is a minimal MQTT 3.1.1 client, just enough to bridge signals
+// to a broker: QoS 0 publishing, subscriptions and keep-alive.
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PacketType is the control packet type in the upper nibble of the fixed header.
+type PacketType byte
+
+const (
+	Connect    PacketType = 1
+	Connack    PacketType = 2
+	Publish    PacketType = 3
+	Subscribe  PacketType = 8
+	Suback     PacketType = 9
+	Pingreq    PacketType = 12
+	Pingresp   PacketType = 13
+	Disconnect PacketType = 14
+)
+
+const (
+	protocolName  = "MQTT"
+	protocolLevel = 4
+
+	// MaxPacketLen bounds the remaining length of packets read, signals are tiny.
+	MaxPacketLen = 1 << 16
+
+	connectFlagCleanSession = 0x02
+	connectFlagPassword     = 0x40
+	connectFlagUsername     = 0x80
+
+	// subscribeFlags are the reserved flags required for SUBSCRIBE packets.
+	subscribeFlags = 0x02
+	// qosMask selects the QoS bits of the PUBLISH flags.
+	qosMask = 0x06
+)
+
+var (
+	ErrMalformedPacket = errors.New("malformed packet")
+	ErrPacketTooLarge  = errors.New("packet too large")
+)
+
+// Packet is a control packet with its body still encoded.
+type Packet struct {
+	Type  PacketType
+	Flags byte
+	Body  []byte
+}
+
+// ReadPacket reads the next control packet.
+func ReadPacket(r io.Reader) (Packet, error) {
+	header := make([]byte, 1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Packet{}, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return Packet{}, fmt.Errorf("%w: remaining length", ErrMalformedPacket)
+		}
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return Packet{}, unexpectedEOF(err)
+		}
+		length += int(b[0]&0x7f) * multiplier
+		if b[0]&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > MaxPacketLen {
+		return Packet{}, fmt.Errorf("%w: %d bytes", ErrPacketTooLarge, length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Packet{}, unexpectedEOF(err)
+	}
+	return Packet{Type: PacketType(header[0] >> 4), Flags: header[0] & 0x0f, Body: body}, nil
+}
+
+// WritePacket writes the packet with a single write.
+func WritePacket(w io.Writer, p Packet) error {
+	frame := []byte{byte(p.Type)<<4 | p.Flags&0x0f}
+	length := len(p.Body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		frame = append(frame, b)
+		if length == 0 {
+			break
+		}
+	}
+	frame = append(frame, p.Body...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ConnectPacket opens a clean session. Empty username and password are omitted.
+func ConnectPacket(clientID, username, password string, keepAlive uint16) Packet {
+	flags := byte(connectFlagCleanSession)
+	if username != "" {
+		flags |= connectFlagUsername
+	}
+	if password != "" {
+		flags |= connectFlagPassword
+	}
+
+	body := appendString(nil, protocolName)
+	body = append(body, protocolLevel, flags)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	body = appendString(body, clientID)
+	if username != "" {
+		body = appendString(body, username)
+	}
+	if password != "" {
+		body = appendString(body, password)
+	}
+	return Packet{Type: Connect, Body: body}
+}
+
+// ConnectInfo is what a broker needs from a CONNECT packet.
+type ConnectInfo struct {
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive uint16
+}
+
+func ParseConnect(p Packet) (ConnectInfo, error) {
+	name, rest, err := readString(p.Body)
+	if err != nil || name != protocolName || len(rest) < 4 {
+		return ConnectInfo{}, fmt.Errorf("%w: connect header", ErrMalformedPacket)
+	}
+	flags := rest[1]
+	info := ConnectInfo{KeepAlive: binary.BigEndian.Uint16(rest[2:4])}
+	rest = rest[4:]
+
+	if info.ClientID, rest, err = readString(rest); err != nil {
+		return ConnectInfo{}, err
+	}
+	if flags&connectFlagUsername != 0 {
+		if info.Username, rest, err = readString(rest); err != nil {
+			return ConnectInfo{}, err
+		}
+	}
+	if flags&connectFlagPassword != 0 {
+		if info.Password, _, err = readString(rest); err != nil {
+			return ConnectInfo{}, err
+		}
+	}
+	return info, nil
+}
+
+// Connack return codes, anything but ConnackAccepted refuses the connection.
+const (
+	ConnackAccepted       byte = 0
+	ConnackBadCredentials byte = 4
+	ConnackNotAuthorized  byte = 5
+)
+
+func ConnackPacket(code byte) Packet {
+	return Packet{Type: Connack, Body: []byte{0, code}}
+}
+
+// PublishPacket publishes with QoS 0, which needs no packet id.
+func PublishPacket(topic string, payload []byte, retain bool) Packet {
+	var flags byte
+	if retain {
+		flags = 0x01
+	}
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return Packet{Type: Publish, Flags: flags, Body: body}
+}
+
+// ParsePublish returns the topic and payload of a PUBLISH packet of any QoS.
+func ParsePublish(p Packet) (topic string, payload []byte, err error) {
+	topic, rest, err := readString(p.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	if p.Flags&qosMask != 0 {
+		if len(rest) < 2 {
+			return "", nil, fmt.Errorf("%w: packet id", ErrMalformedPacket)
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, nil
+}
+
+// SubscribePacket subscribes to the filters with QoS 0.
+func SubscribePacket(id uint16, filters ...string) Packet {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, filter := range filters {
+		body = appendString(body, filter)
+		body = append(body, 0)
+	}
+	return Packet{Type: Subscribe, Flags: subscribeFlags, Body: body}
+}
+
+// ParseSubscribe returns the packet id and the topic filters of a SUBSCRIBE packet.
+func ParseSubscribe(p Packet) (uint16, []string, error) {
+	if len(p.Body) < 2 {
+		return 0, nil, fmt.Errorf("%w: packet id", ErrMalformedPacket)
+	}
+	id := binary.BigEndian.Uint16(p.Body)
+
+	var (
+		filters []string
+		filter  string
+		err     error
+	)
+	for rest := p.Body[2:]; len(rest) > 0; rest = rest[1:] {
+		if filter, rest, err = readString(rest); err != nil || len(rest) == 0 {
+			return 0, nil, fmt.Errorf("%w: topic filter", ErrMalformedPacket)
+		}
+		filters = append(filters, filter)
+	}
+	return id, filters, nil
+}
+
+// SubackPacket grants QoS 0 to every filter.
+func SubackPacket(id uint16, filters int) Packet {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = append(body, make([]byte, filters)...)
+	return Packet{Type: Suback, Body: body}
+}
+
+// MatchTopic reports whether the topic matches the filter with its "+" and "#" wildcards.
+func MatchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, fmt.Errorf("%w: string length", ErrMalformedPacket)
+	}
+	length := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+length {
+		return "", nil, fmt.Errorf("%w: string", ErrMalformedPacket)
+	}
+	return string(b[2 : 2+length]), b[2+length:], nil
+}
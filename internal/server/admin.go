@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/auth"
+	"github.com/serg-pe/signals/internal/client"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	pathClientID = "id"
+
+	adminDisconnectReason = "disconnected by admin"
+	// adminPublisherPrefix marks signals injected by an admin in the history.
+	adminPublisherPrefix = "admin:"
+)
+
+// adminIdentityKey holds the identity of the admin in the request context.
+type adminIdentityKey struct{}
+
+type adminChannel struct {
+	Name        string          `json:"name"`
+	Publishers  int             `json:"publishers"`
+	Subscribers int             `json:"subscribers"`
+	Seq         uint32          `json:"seq"`
+	Retained    *signals.Signal `json:"retained,omitempty"`
+}
+
+type adminClient struct {
+	ID           int       `json:"id"`
+	Identity     string    `json:"identity"`
+	Channel      string    `json:"channel"`
+	Role         string    `json:"role"`
+	Transport    string    `json:"transport"`
+	Address      string    `json:"address"`
+	Protocol     string    `json:"protocol"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastActivity time.Time `json:"last_activity"`
+	Received     uint64    `json:"received"`
+	Sent         uint64    `json:"sent"`
+	Dropped      uint64    `json:"dropped"`
+}
+
+type adminSignal struct {
+	Signal signals.Signal `json:"signal"`
+}
+
+type adminSignalResult struct {
+	Seq         uint32 `json:"seq"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// apiError is the body of failed API requests.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// setupAdmin enables the admin API when admin tokens are configured.
+func (s *Server) setupAdmin(cfg []config.AdminTokenConfig) error {
+	if len(cfg) == 0 {
+		return nil
+	}
+
+	tokens := make([]config.TokenConfig, 0, len(cfg))
+	for i, tokenCfg := range cfg {
+		name := tokenCfg.Name
+		if name == "" {
+			name = fmt.Sprintf("admin-%d", i)
+		}
+		// Admin tokens carry no channel permissions, the role only satisfies validation.
+		tokens = append(tokens, config.TokenConfig{Name: name, Token: tokenCfg.Token, Role: string(auth.RoleBoth)})
+	}
+
+	admin, err := auth.NewTokens(tokens)
+	if err != nil {
+		return fmt.Errorf("init admin tokens: %w", err)
+	}
+	s.admin = admin
+	return nil
+}
+
+func (s *Server) setupAdminRoutes(mux *http.ServeMux) {
+	if s.admin == nil {
+		return
+	}
+
+	mux.HandleFunc("GET /admin/channels", s.adminOnly(s.adminListChannels))
+	mux.HandleFunc("GET /admin/channels/{"+pathChannelName+"}/clients", s.adminOnly(s.adminListChannelClients))
+	mux.HandleFunc("POST /admin/channels/{"+pathChannelName+"}/signal", s.adminOnly(s.adminInjectSignal))
+	mux.HandleFunc("GET /admin/clients", s.adminOnly(s.adminListClients))
+	mux.HandleFunc("DELETE /admin/clients/{"+pathClientID+"}", s.adminOnly(s.adminDisconnectClient))
+}
+
+// adminOnly rejects requests without a valid admin token.
+func (s *Server) adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := s.admin.Authenticate(r)
+		if err != nil {
+			s.logger.Debug("admin authentication failed", zap.String("client", r.RemoteAddr), zap.Error(err))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="signals-admin"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "unauthorized"})
+			return
+		}
+
+		s.logger.Debug("admin request", zap.String("admin", identity.Name), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+		handler(w, r.WithContext(context.WithValue(r.Context(), adminIdentityKey{}, identity)))
+	}
+}
+
+func (s *Server) adminListChannels(w http.ResponseWriter, r *http.Request) {
+	chs := s.channels.All()
+	sort.Slice(chs, func(i, j int) bool { return chs[i].name < chs[j].name })
+
+	result := make([]adminChannel, 0, len(chs))
+	for _, ch := range chs {
+		seq, last, hasLast := ch.state()
+		info := adminChannel{
+			Name:        ch.name,
+			Publishers:  len(ch.Publishers()),
+			Subscribers: len(ch.Subscribers()),
+			Seq:         seq,
+		}
+		if hasLast {
+			info.Retained = &last.Signal
+		}
+		result = append(result, info)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) adminListChannelClients(w http.ResponseWriter, r *http.Request) {
+	ch, ok := s.channels.Get(r.PathValue(pathChannelName))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "channel not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminClients(append(ch.Publishers(), ch.Subscribers()...)))
+}
+
+func (s *Server) adminListClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, adminClients(s.clients.All()))
+}
+
+// adminDisconnectClient closes the connection with a policy violation close frame.
+func (s *Server) adminDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue(pathClientID))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid client id"})
+		return
+	}
+
+	c, err := s.clients.Get(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "client not found"})
+		return
+	}
+
+	s.logger.Info("disconnecting client by admin request", zap.Int("id", id), zap.String("identity", c.Identity()))
+	c.Shutdown(websocket.ClosePolicyViolation, adminDisconnectReason)
+	c.Stop()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminInjectSignal publishes a state signal to the channel as if a publisher sent it.
+func (s *Server) adminInjectSignal(w http.ResponseWriter, r *http.Request) {
+	req := adminSignal{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, signals.MaxMessageLen)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	if req.Signal != signals.SignalOn && req.Signal != signals.SignalOff {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "only on and off can be injected"})
+		return
+	}
+
+	channelName := r.PathValue(pathChannelName)
+
+	admin, _ := r.Context().Value(adminIdentityKey{}).(auth.Identity)
+	result := s.publish(channelName, req.Signal, adminPublisherPrefix+admin.Name)
+	writeJSON(w, http.StatusOK, adminSignalResult{Seq: result.Seq, Subscribers: result.Subscribers})
+}
+
+func adminClients(clients []*client.Client) []adminClient {
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID() < clients[j].ID() })
+
+	result := make([]adminClient, 0, len(clients))
+	for _, c := range clients {
+		result = append(result, adminClient{
+			ID:           c.ID(),
+			Identity:     c.Identity(),
+			Channel:      c.Channel(),
+			Role:         c.Role().String(),
+			Transport:    c.Transport(),
+			Address:      c.RemoteAddr(),
+			Protocol:     c.Protocol(),
+			ConnectedAt:  c.ConnectedAt(),
+			LastActivity: c.LastActivity(),
+			Received:     c.Received(),
+			Sent:         c.Sent(),
+			Dropped:      c.Dropped(),
+		})
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
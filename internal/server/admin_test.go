@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAdminAPI(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{}, config.AuthConfig{Admin: []config.AdminTokenConfig{{Name: "ops", Token: "admin-secret"}}}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	sub, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/kitchen", nil)
+	require.NoError(t, err)
+	defer sub.Close()
+	_, presence, err := sub.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{byte(signals.SignalPublisherDisconnected)}, presence)
+
+	request := func(method, path, token, body string) (int, []byte) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, data
+	}
+
+	code, _ := request("GET", "/admin/channels", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = request("GET", "/admin/channels", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, body := request("GET", "/admin/channels", "admin-secret", "")
+	require.Equal(t, http.StatusOK, code)
+	channels := []adminChannel{}
+	require.NoError(t, json.Unmarshal(body, &channels))
+	assert.Equal(t, []adminChannel{{Name: "kitchen", Publishers: 0, Subscribers: 1}}, channels)
+
+	code, body = request("GET", "/admin/channels/kitchen/clients", "admin-secret", "")
+	require.Equal(t, http.StatusOK, code)
+	clients := []adminClient{}
+	require.NoError(t, json.Unmarshal(body, &clients))
+	require.Len(t, clients, 1)
+	assert.Equal(t, "subscriber", clients[0].Role)
+	assert.Equal(t, sub.LocalAddr().String(), clients[0].Address)
+
+	code, _ = request("GET", "/admin/channels/hall/clients", "admin-secret", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = request("POST", "/admin/channels/kitchen/signal", "admin-secret", `{"signal":"ping"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, body = request("POST", "/admin/channels/kitchen/signal", "admin-secret", `{"signal":"on"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"seq":1,"subscribers":1}`, string(body))
+	_, msg, err := sub.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{byte(signals.SignalOn)}, msg)
+
+	code, _ = request("DELETE", "/admin/clients/42", "admin-secret", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = request("DELETE", fmt.Sprintf("/admin/clients/%d", clients[0].ID), "admin-secret", "")
+	assert.Equal(t, http.StatusNoContent, code)
+
+	_, _, err = sub.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+	assert.Eventually(t, func() bool { return s.clients.Len() == 0 }, time.Second, time.Millisecond*10)
+}
+
+func TestAdminAPIDisabled(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/admin/channels")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/serg-pe/signals/internal/auth"
+	"github.com/serg-pe/signals/internal/config"
+	"go.uber.org/zap"
+)
+
+const (
+	anonymousIdentity = "anonymous"
+)
+
+// setupAuth chains client certificates, static tokens and JWT validation,
+// whichever are configured.
+func (s *Server) setupAuth(cfg config.AuthConfig) error {
+	chain := auth.Chain{}
+
+	if len(cfg.Certificates) > 0 {
+		certificates, err := auth.NewCertificates(cfg.Certificates)
+		if err != nil {
+			return fmt.Errorf("init certificates: %w", err)
+		}
+		chain = append(chain, certificates)
+	}
+
+	if len(cfg.Tokens) > 0 {
+		tokens, err := auth.NewTokens(cfg.Tokens)
+		if err != nil {
+			return fmt.Errorf("init tokens: %w", err)
+		}
+		chain = append(chain, tokens)
+	}
+
+	if cfg.JWT.JWKSFile != "" {
+		jwt, err := auth.NewJWT(cfg.JWT, s.logger.Named("jwt"))
+		if err != nil {
+			return fmt.Errorf("init jwt: %w", err)
+		}
+		s.jwt = jwt
+		chain = append(chain, jwt)
+	}
+
+	s.auth = chain
+	return nil
+}
+
+var errAccessDenied = errors.New("access denied")
+
+// authorize checks the credentials of a request to join or publish to the
+// channel. It answers 401 or 403 itself and returns that status if the client
+// may not proceed, http.StatusOK otherwise.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, channelName string, isPub bool) (auth.Identity, int) {
+	identity, err := s.identify(r, channelName, isPub)
+	switch {
+	case err == nil:
+		return identity, http.StatusOK
+	case errors.Is(err, errAccessDenied):
+		w.WriteHeader(http.StatusForbidden)
+		return identity, http.StatusForbidden
+	case errors.Is(err, auth.ErrNoCredentials):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="signals"`)
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="signals", error="invalid_token"`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	return identity, http.StatusUnauthorized
+}
+
+// identify authenticates the request and checks its permissions for the
+// channel. It returns errAccessDenied if the identity may not use the channel.
+func (s *Server) identify(r *http.Request, channelName string, isPub bool) (auth.Identity, error) {
+	if s.auth == nil {
+		return auth.Identity{Name: anonymousIdentity, Role: auth.RoleBoth}, nil
+	}
+
+	identity, err := s.auth.Authenticate(r)
+	if err != nil {
+		s.logger.Debug("authentication failed", zap.String("client", r.RemoteAddr), zap.Error(err))
+		return identity, err
+	}
+
+	if !identity.Allows(channelName, isPub) {
+		s.logger.Debug(
+			"access denied",
+			zap.String("client", r.RemoteAddr),
+			zap.String("identity", identity.Name),
+			zap.String("channel", channelName),
+			zap.Bool("publisher", isPub),
+		)
+		return identity, errAccessDenied
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/internal/mqtt"
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	// bridgeQueueSize bounds the signals waiting to be published to the broker.
+	bridgeQueueSize = 64
+	// bridgePublisher names the bridge as the publisher of signals from the broker.
+	bridgePublisher = "mqtt"
+)
+
+// broker is the part of mqtt.Client the bridge uses.
+type broker interface {
+	Publish(topic string, payload []byte, retain bool) error
+	Subscribe(filter string, handler mqtt.Handler) error
+}
+
+type bridgedSignal struct {
+	topic  string
+	retain bool
+	msg    signals.Message
+}
+
+// mqttBridge publishes the state signals of channels to MQTT topics and
+// publishes messages of subscribed topics to channels.
+type mqttBridge struct {
+	logger *zap.Logger
+	client *mqtt.Client
+	broker broker
+
+	// topics maps a channel to the topics its signals are published to.
+	topics map[string][]config.MQTTTopicConfig
+	outbox chan bridgedSignal
+}
+
+func (s *Server) setupMQTT(cfg config.MQTTConfig) error {
+	logger := s.logger.Named("mqtt")
+	client := mqtt.New(mqtt.Options{
+		Address:           cfg.Broker,
+		ClientID:          cfg.ClientID,
+		Username:          cfg.Username,
+		Password:          cfg.Password,
+		KeepAlive:         cfg.KeepAlive,
+		ReconnectMinDelay: cfg.ReconnectDelay,
+	}, logger)
+
+	bridge, err := newMQTTBridge(cfg.Topics, client, logger, s.injectSignal)
+	if err != nil {
+		return fmt.Errorf("init mqtt bridge: %w", err)
+	}
+	bridge.client = client
+	s.bridge = bridge
+	return nil
+}
+
+func newMQTTBridge(topics []config.MQTTTopicConfig, b broker, logger *zap.Logger, inject func(channel string, msg signals.Message)) (*mqttBridge, error) {
+	bridge := &mqttBridge{
+		logger: logger,
+		broker: b,
+		topics: make(map[string][]config.MQTTTopicConfig),
+		outbox: make(chan bridgedSignal, bridgeQueueSize),
+	}
+
+	for _, topic := range topics {
+		if topic.Channel == "" {
+			return nil, errors.New("topic without channel")
+		}
+	}
+	// The broker would hand our own messages back to us and the signal would loop
+	// forever, whichever entries or channels the topics belong to.
+	for _, sub := range topics {
+		for _, pub := range topics {
+			if sub.Subscribe != "" && pub.Publish != "" && mqtt.MatchTopic(sub.Subscribe, pub.Publish) {
+				return nil, fmt.Errorf("channel %q subscribes to topic %q of channel %q", sub.Channel, pub.Publish, pub.Channel)
+			}
+		}
+	}
+
+	for _, topic := range topics {
+		if topic.Publish != "" {
+			bridge.topics[topic.Channel] = append(bridge.topics[topic.Channel], topic)
+		}
+		if topic.Subscribe != "" {
+			channel := topic.Channel
+			err := b.Subscribe(topic.Subscribe, func(topicName string, payload []byte) {
+				msg, err := decodeBridgePayload(payload)
+				if err != nil {
+					logger.Debug("decode mqtt message", zap.String("topic", topicName), zap.Error(err))
+					return
+				}
+				inject(channel, msg)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("subscribe %q: %w", topic.Subscribe, err)
+			}
+		}
+	}
+
+	return bridge, nil
+}
+
+// decodeBridgePayload accepts a JSON message or just the name of the signal, "on" or "off".
+func decodeBridgePayload(payload []byte) (signals.Message, error) {
+	var (
+		msg signals.Message
+		err error
+	)
+
+	payload = bytes.TrimSpace(payload)
+	if bytes.HasPrefix(payload, []byte("{")) {
+		msg, err = signals.DecodeJSON(payload)
+	} else {
+		err = msg.Signal.UnmarshalText(bytes.ToLower(payload))
+	}
+	if err != nil {
+		return msg, err
+	}
+
+	if msg.Signal != signals.SignalOn && msg.Signal != signals.SignalOff {
+		return msg, errNotStateSignal
+	}
+	return msg, nil
+}
+
+// forward queues a state signal of the channel for its topics. Signals are
+// dropped while the queue is full, a broker that is down must not hold up channels.
+func (b *mqttBridge) forward(channel string, msg signals.Message) {
+	for _, topic := range b.topics[channel] {
+		select {
+		case b.outbox <- bridgedSignal{topic: topic.Publish, retain: topic.Retain, msg: msg}:
+		default:
+			b.logger.Warn("mqtt queue is full, signal dropped", zap.String("channel", channel), zap.String("topic", topic.Publish))
+		}
+	}
+}
+
+// run keeps the broker session and publishes queued signals until ctx is done.
+func (b *mqttBridge) run(ctx context.Context) {
+	if b.client != nil {
+		go b.client.Run(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case out := <-b.outbox:
+			payload, err := signals.EncodeJSON(out.msg)
+			if err != nil {
+				b.logger.Error("encode mqtt message", zap.Error(err))
+				continue
+			}
+			if err := b.broker.Publish(out.topic, payload, out.retain); err != nil {
+				b.logger.Warn("publish to mqtt", zap.String("topic", out.topic), zap.Error(err))
+			}
+		}
+	}
+}
+
+// injectSignal publishes a signal that arrived from the broker to the channel.
+func (s *Server) injectSignal(channel string, msg signals.Message) {
+	s.metrics.received.Inc(msg.Signal.String())
+	result := s.publish(channel, msg.Signal, bridgePublisher)
+	s.logger.Debug("signal from mqtt", zap.String("channel", channel), zap.Stringer("signal", msg.Signal), zap.Int("subscribers", result.Subscribers))
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/internal/mqtt"
+	"github.com/serg-pe/signals/internal/mqtt/mqtttest"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDecodeBridgePayload(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		payload  string
+		expected signals.Signal
+		err      bool
+	}{
+		{name: "name", payload: "on", expected: signals.SignalOn},
+		{name: "upper case", payload: "OFF\n", expected: signals.SignalOff},
+		{name: "json", payload: `{"signal":"off"}`, expected: signals.SignalOff},
+		{name: "not a state signal", payload: "ping", err: true},
+		{name: "garbage", payload: "maybe", err: true},
+		{name: "json without signal", payload: `{"seq":3}`, err: true},
+		{name: "empty json", payload: `{}`, err: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			msg, err := decodeBridgePayload([]byte(tc.payload))
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, msg.Signal)
+		})
+	}
+}
+
+func TestMQTTBridgeLoop(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		topics []config.MQTTTopicConfig
+		err    bool
+	}{
+		{
+			name:   "same entry",
+			topics: []config.MQTTTopicConfig{{Channel: "kitchen", Publish: "plant/kitchen", Subscribe: "plant/#"}},
+			err:    true,
+		},
+		{
+			name: "separate entries",
+			topics: []config.MQTTTopicConfig{
+				{Channel: "kitchen", Publish: "plant/kitchen"},
+				{Channel: "kitchen", Subscribe: "plant/#"},
+			},
+			err: true,
+		},
+		{
+			name: "across channels",
+			topics: []config.MQTTTopicConfig{
+				{Channel: "kitchen", Publish: "plant/kitchen", Subscribe: "plant/hall"},
+				{Channel: "hall", Publish: "plant/hall", Subscribe: "plant/kitchen"},
+			},
+			err: true,
+		},
+		{
+			name: "no loop",
+			topics: []config.MQTTTopicConfig{
+				{Channel: "kitchen", Publish: "plant/kitchen/state", Subscribe: "plant/kitchen/set"},
+				{Channel: "hall", Publish: "plant/hall/state", Subscribe: "plant/+/set"},
+			},
+			err: false,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newMQTTBridge(tc.topics, nopBroker{}, zap.NewNop(), func(string, signals.Message) {})
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// nopBroker accepts every publish and subscription.
+type nopBroker struct{}
+
+func (nopBroker) Publish(string, []byte, bool) error   { return nil }
+func (nopBroker) Subscribe(string, mqtt.Handler) error { return nil }
+
+func TestMQTTBridge(t *testing.T) {
+	t.Parallel()
+
+	broker, err := mqtttest.NewBroker()
+	require.NoError(t, err)
+	defer broker.Close()
+
+	s, err := New(config.ServerConfig{MQTT: config.MQTTConfig{
+		Broker:         broker.Addr,
+		ClientID:       "signals",
+		ReconnectDelay: time.Millisecond * 10,
+		Topics: []config.MQTTTopicConfig{
+			{Channel: "kitchen", Publish: "plant/kitchen/state", Subscribe: "plant/kitchen/set"},
+		},
+	}}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.bridge.run(ctx)
+	require.Eventually(t, func() bool { return broker.Subscribed("plant/kitchen/set") }, time.Second*5, time.Millisecond*10)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/connection/kitchen"
+	sub, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer sub.Close()
+	pub, _, err := websocket.DefaultDialer.Dial(url+"?is-initiator=true", nil)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	read := func() signals.Signal {
+		require.NoError(t, sub.SetReadDeadline(time.Now().Add(time.Second*5)))
+		_, data, err := sub.ReadMessage()
+		require.NoError(t, err)
+		return signals.Signal(data[0])
+	}
+	assert.Equal(t, signals.SignalPublisherDisconnected, read())
+	assert.Equal(t, signals.SignalPublisherConnected, read())
+
+	// Signals of the channel are published to the broker.
+	require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signals.SignalOn)}))
+	assert.Equal(t, signals.SignalOn, read())
+	select {
+	case msg := <-broker.Published():
+		assert.Equal(t, "plant/kitchen/state", msg.Topic)
+		assert.JSONEq(t, `{"signal":"on","seq":1}`, string(msg.Payload))
+	case <-time.After(time.Second * 5):
+		t.Fatal("signal not published to the broker")
+	}
+
+	// Messages of the broker are published to the channel.
+	broker.Publish("plant/kitchen/set", []byte("off"))
+	assert.Equal(t, signals.SignalOff, read())
+}
@@ -0,0 +1,570 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/internal/client"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/internal/history"
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultChannelName = "default"
+
+	defaultReplayLimit = 1000
+	// maxRetainedChannels bounds the dropped channels whose retained signals
+	// are kept without a history, the least recently dropped are forgotten.
+	maxRetainedChannels = 10000
+)
+
+// channel pairs publishers with the subscribers of the same topic.
+type channel struct {
+	logger *zap.Logger
+	name   string
+
+	mu          *sync.RWMutex
+	publishers  map[int]*client.Client
+	subscribers map[int]*client.Client
+	// acknowledged holds ids of subscribers that acked the last state signal.
+	acknowledged map[int]struct{}
+
+	// seq numbers state signals published in the channel.
+	seq uint32
+
+	retain  bool
+	hasLast bool
+	last    signals.Message
+
+	observe observer
+	// closed is set once the channel is dropped, later signals must go to its successor.
+	closed bool
+
+	// history records state signals and replays them to resuming subscribers, nil disables it.
+	history     *history.Store
+	replayLimit int
+}
+
+// observer is told about state signals and publisher presence changes of a
+// channel. It is called with the channel lock held and must not block.
+type observer func(channel string, msg signals.Message)
+
+// inheritance is the state a channel takes over from its predecessor or from
+// the history.
+type inheritance struct {
+	seq     uint32
+	last    signals.Message
+	hasLast bool
+}
+
+// backlog holds the recorded state signals a resuming subscriber has missed.
+// It is read before the channel is locked and covers the signals up to upTo.
+type backlog struct {
+	records []history.Record
+	upTo    uint32
+}
+
+func newChannel(logger *zap.Logger, name string, retain bool, observe observer, store *history.Store, replayLimit int) *channel {
+	ch := &channel{
+		logger:      logger.With(zap.String("channel", name)),
+		name:        name,
+		mu:          &sync.RWMutex{},
+		publishers:  make(map[int]*client.Client),
+		subscribers: make(map[int]*client.Client),
+
+		acknowledged: make(map[int]struct{}),
+
+		retain:  retain,
+		observe: observe,
+
+		history:     store,
+		replayLimit: replayLimit,
+	}
+	return ch
+}
+
+// restore makes a new channel continue the sequence numbers and the retained
+// signal of an earlier incarnation.
+func (ch *channel) restore(state inheritance) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.seq = state.seq
+	if ch.retain && state.hasLast {
+		ch.hasLast = true
+		ch.last = state.last
+	}
+}
+
+// add registers the client in the channel, missed is what the history has
+// recorded for a resuming subscriber. Subscribers learn about publisher
+// presence while the lock is held, so the order of lifecycle events is kept.
+// It reports false if the channel has been dropped.
+func (ch *channel) add(c *client.Client, missed *backlog) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		return false
+	}
+
+	if c.Role() == client.RolePublisher {
+		ch.publishers[c.ID()] = c
+		if len(ch.publishers) == 1 {
+			ch.announce(signals.Message{Signal: signals.SignalPublisherConnected})
+		}
+		ch.sendStatistic(c)
+		return true
+	}
+
+	ch.subscribers[c.ID()] = c
+	presence := signals.SignalPublisherDisconnected
+	if len(ch.publishers) > 0 {
+		presence = signals.SignalPublisherConnected
+	}
+	// The state a subscriber has missed bypasses its send queue, which is much shorter than a replay may be.
+	state := []signals.Message{{Signal: presence}}
+	if replayed, ok := ch.replay(c, missed); ok {
+		state = append(state, replayed...)
+	} else if ch.hasLast && ch.missed(c) {
+		state = append(state, ch.last)
+	}
+	if err := c.Replay(state); err != nil {
+		ch.logger.Debug("send channel state", zap.Int("id", c.ID()), zap.Error(err))
+	}
+	ch.updateStatistic()
+	return true
+}
+
+// missed reports whether a subscriber has not seen the retained signal yet. A
+// resuming client that is ahead of the channel saw an earlier incarnation of it.
+func (ch *channel) missed(c *client.Client) bool {
+	after, resuming := c.ResumeAfter()
+	return !resuming || after < ch.last.Seq || after > ch.seq
+}
+
+// replay returns the recorded state signals a resuming subscriber has missed,
+// up to the replay limit of the newest ones. It reports false if the history
+// cannot tell, the caller must hold the lock.
+func (ch *channel) replay(c *client.Client, missed *backlog) ([]signals.Message, bool) {
+	after, resuming := c.ResumeAfter()
+	if missed == nil || !resuming || after > ch.seq {
+		return nil, false
+	}
+
+	records := missed.records
+	if ch.seq > missed.upTo {
+		// Signals published after the backlog was read, the only history read under the lock.
+		newer, err := ch.history.After(ch.name, missed.upTo, int(ch.seq-missed.upTo))
+		if err != nil {
+			ch.logger.Error("read history", zap.Error(err))
+			return nil, false
+		}
+		records = append(records, newer...)
+	}
+	if len(records) > ch.replayLimit {
+		records = records[len(records)-ch.replayLimit:]
+	}
+
+	replayed := make([]signals.Message, 0, len(records))
+	for _, record := range records {
+		replayed = append(replayed, signals.Message{Signal: record.Signal, Seq: record.Seq})
+	}
+	return replayed, true
+}
+
+func (ch *channel) remove(c *client.Client) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if c.Role() != client.RolePublisher {
+		if _, ok := ch.subscribers[c.ID()]; !ok {
+			return
+		}
+		delete(ch.subscribers, c.ID())
+		delete(ch.acknowledged, c.ID())
+		ch.updateStatistic()
+		return
+	}
+
+	if _, ok := ch.publishers[c.ID()]; !ok {
+		return
+	}
+	delete(ch.publishers, c.ID())
+	if len(ch.publishers) == 0 {
+		ch.announce(signals.Message{Signal: signals.SignalPublisherDisconnected})
+	}
+}
+
+// publishResult tells how a state signal was relayed.
+type publishResult struct {
+	Seq         uint32 `json:"seq"`
+	Subscribers int    `json:"subscribers"`
+	// Delivered counts subscribers that queued the signal, Failed the ones that refused it.
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+}
+
+// publish numbers a state signal, records it, relays it to every subscriber
+// and resets acknowledgements. publisher names who sent the signal. It
+// reports false if the channel has been dropped.
+func (ch *channel) publish(signal signals.Signal, publisher string) (publishResult, bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		return publishResult{}, false
+	}
+
+	ch.seq++
+	msg := signals.Message{Signal: signal, Seq: ch.seq}
+	if ch.history != nil {
+		record := history.Record{Time: time.Now().UTC(), Seq: msg.Seq, Signal: signal, Publisher: publisher}
+		if err := ch.history.Append(ch.name, record); err != nil {
+			ch.logger.Error("record signal", zap.Uint32("seq", msg.Seq), zap.Error(err))
+		}
+	}
+	if ch.retain {
+		ch.hasLast = true
+		ch.last = msg
+	}
+
+	delivered := ch.announce(msg)
+	if len(ch.acknowledged) > 0 {
+		clear(ch.acknowledged)
+		ch.updateStatistic()
+	}
+
+	return publishResult{
+		Seq:         msg.Seq,
+		Subscribers: len(ch.subscribers),
+		Delivered:   delivered,
+		Failed:      len(ch.subscribers) - delivered,
+	}, true
+}
+
+// close marks an empty channel as dropped and returns the state its successor
+// inherits. It reports false if the channel still has clients.
+func (ch *channel) close() (inheritance, bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if len(ch.publishers) > 0 || len(ch.subscribers) > 0 {
+		return inheritance{}, false
+	}
+	ch.closed = true
+	return inheritance{seq: ch.seq, last: ch.last, hasLast: ch.hasLast}, true
+}
+
+// acknowledge marks that the subscriber has received the last state signal.
+// Legacy clients have no sequence numbers, their acks always count.
+func (ch *channel) acknowledge(c *client.Client, seq uint32) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if _, ok := ch.subscribers[c.ID()]; !ok {
+		return
+	}
+	if c.Sequenced() && seq != ch.seq {
+		return
+	}
+	if _, ok := ch.acknowledged[c.ID()]; ok {
+		return
+	}
+	ch.acknowledged[c.ID()] = struct{}{}
+	ch.updateStatistic()
+}
+
+// updateStatistic pushes the subscribers statistic to every publisher, the caller must hold the lock.
+func (ch *channel) updateStatistic() {
+	for _, pub := range ch.publishers {
+		ch.sendStatistic(pub)
+	}
+}
+
+// sendStatistic pushes the subscribers statistic to a publisher, the caller must hold the lock.
+func (ch *channel) sendStatistic(pub *client.Client) {
+	msg := signals.SubscribersStatistic{
+		Subscribers:  uint32(len(ch.subscribers)),
+		Acknowledged: uint32(len(ch.acknowledged)),
+	}.Message()
+
+	if err := pub.Send(msg); err != nil {
+		ch.logger.Debug("send subscribers statistic", zap.Int("id", pub.ID()), zap.Error(err))
+	}
+}
+
+// announce broadcasts the message and tells the observer about it, the caller must hold the lock.
+func (ch *channel) announce(msg signals.Message) int {
+	delivered := ch.broadcast(msg)
+	if ch.observe != nil {
+		ch.observe(ch.name, msg)
+	}
+	return delivered
+}
+
+// broadcast sends the message to every subscriber and returns how many
+// accepted it, the caller must hold the lock.
+func (ch *channel) broadcast(msg signals.Message) int {
+	delivered := 0
+	for id, sub := range ch.subscribers {
+		if err := sub.Send(msg); err != nil {
+			ch.logger.Debug("broadcast signal", zap.Int("id", id), zap.Error(err))
+			continue
+		}
+		delivered++
+	}
+	return delivered
+}
+
+// state returns the sequence number of the last state signal and the retained one, if any.
+func (ch *channel) state() (seq uint32, last signals.Message, hasLast bool) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	return ch.seq, ch.last, ch.hasLast
+}
+
+func (ch *channel) Publishers() []*client.Client {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	return snapshot(ch.publishers)
+}
+
+func (ch *channel) Subscribers() []*client.Client {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	return snapshot(ch.subscribers)
+}
+
+func snapshot(clients map[int]*client.Client) []*client.Client {
+	result := make([]*client.Client, 0, len(clients))
+	for _, c := range clients {
+		result = append(result, c)
+	}
+	return result
+}
+
+// channels creates channels on first use and drops them once the last client
+// leaves. Retained signals and sequence numbers outlive their channels and are
+// restored from the history when the channel is used again. Without a history
+// only the retained signals of the most recently dropped channels are kept.
+//
+// The history is read before the lock is taken, so disk I/O never holds up
+// other channels.
+type channels struct {
+	logger  *zap.Logger
+	cfg     config.ServerConfig
+	observe observer
+	history *history.Store
+	mu      *sync.Mutex
+	byName  map[string]*channel
+	// retained holds the state of dropped channels with a retained signal
+	// without a history, dropOrder their names, least recently dropped first.
+	retained  map[string]*list.Element
+	dropOrder *list.List
+}
+
+// retainedChannel is an element of channels.dropOrder.
+type retainedChannel struct {
+	name  string
+	state inheritance
+}
+
+func newChannels(cfg config.ServerConfig, logger *zap.Logger, observe observer, store *history.Store) *channels {
+	return &channels{
+		logger:  logger,
+		cfg:     cfg,
+		observe: observe,
+		history: store,
+		mu:      &sync.Mutex{},
+		byName:  make(map[string]*channel),
+
+		retained:  make(map[string]*list.Element),
+		dropOrder: list.New(),
+	}
+}
+
+func (cs *channels) Join(name string, c *client.Client) *channel {
+	recorded := cs.recorded(name)
+	missed := cs.backlog(name, c, recorded)
+	for {
+		cs.mu.Lock()
+		ch := cs.open(name, recorded)
+		cs.mu.Unlock()
+
+		if ch.add(c, missed) {
+			return ch
+		}
+	}
+}
+
+// Leave removes the client from the channel. Subscribers are told about it
+// without the lock, so a large channel never holds up the others.
+func (cs *channels) Leave(name string, c *client.Client) {
+	cs.mu.Lock()
+	ch, ok := cs.byName[name]
+	cs.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ch.remove(c)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.drop(name, ch)
+}
+
+// Publish publishes a state signal to the channel. A channel without clients
+// is created for the signal, so it is numbered, retained, recorded and
+// observed like any other, and dropped right after.
+func (cs *channels) Publish(name string, signal signals.Signal, publisher string) publishResult {
+	recorded := cs.recorded(name)
+	for {
+		cs.mu.Lock()
+		ch := cs.open(name, recorded)
+		cs.mu.Unlock()
+
+		result, ok := ch.publish(signal, publisher)
+
+		cs.mu.Lock()
+		cs.drop(name, ch)
+		cs.mu.Unlock()
+		if ok {
+			return result
+		}
+	}
+}
+
+// open returns the channel, creating it if needed. A new channel inherits the
+// recorded state or without a history the state of its dropped predecessor.
+// The caller must hold the lock.
+func (cs *channels) open(name string, recorded inheritance) *channel {
+	if ch, ok := cs.byName[name]; ok {
+		return ch
+	}
+
+	ch := newChannel(cs.logger, name, cs.cfg.RetainLastSignalFor(name), cs.observe, cs.history, cs.replayLimit())
+	if cs.history != nil {
+		// A predecessor may have recorded signals since recorded was read.
+		if last, ok := cs.history.Cached(name); ok && last.Seq > recorded.seq {
+			recorded = inherit(last)
+		}
+		ch.restore(recorded)
+	} else if elem, ok := cs.retained[name]; ok {
+		ch.restore(elem.Value.(retainedChannel).state)
+		cs.dropOrder.Remove(elem)
+		delete(cs.retained, name)
+	}
+	cs.byName[name] = ch
+	return ch
+}
+
+// drop removes the channel once it has no clients. Without a history it keeps
+// the retained signal for its successor, the caller must hold the lock.
+func (cs *channels) drop(name string, ch *channel) {
+	if cs.byName[name] != ch {
+		return
+	}
+	state, ok := ch.close()
+	if !ok {
+		return
+	}
+	delete(cs.byName, name)
+	if cs.history != nil || !state.hasLast {
+		return
+	}
+
+	cs.retained[name] = cs.dropOrder.PushBack(retainedChannel{name: name, state: state})
+	if cs.dropOrder.Len() > maxRetainedChannels {
+		oldest := cs.dropOrder.Front()
+		cs.dropOrder.Remove(oldest)
+		delete(cs.retained, oldest.Value.(retainedChannel).name)
+	}
+}
+
+// recorded returns the state of the channel after its newest recorded signal.
+// It must be called without the lock.
+func (cs *channels) recorded(name string) inheritance {
+	if cs.history == nil {
+		return inheritance{}
+	}
+	last, ok, err := cs.history.Last(name)
+	if err != nil {
+		cs.logger.Error("read last recorded signal", zap.String("channel", name), zap.Error(err))
+		return inheritance{}
+	}
+	if !ok {
+		return inheritance{}
+	}
+	return inherit(last)
+}
+
+// inherit returns the state of a channel whose newest signal is the record.
+func inherit(last history.Record) inheritance {
+	return inheritance{seq: last.Seq, last: signals.Message{Signal: last.Signal, Seq: last.Seq}, hasLast: true}
+}
+
+// backlog reads the signals a resuming subscriber has missed up to the
+// recorded state, at most the replay limit of the newest ones. It returns nil
+// if the history cannot tell and must be called without the lock.
+func (cs *channels) backlog(name string, c *client.Client, recorded inheritance) *backlog {
+	after, resuming := c.ResumeAfter()
+	if cs.history == nil || !resuming || c.Role() == client.RolePublisher {
+		return nil
+	}
+
+	missed := &backlog{upTo: max(after, recorded.seq)}
+	if after >= recorded.seq {
+		return missed
+	}
+	limit := cs.replayLimit()
+	start := after
+	if recorded.seq-after > uint32(limit) {
+		start = recorded.seq - uint32(limit)
+	}
+
+	records, err := cs.history.After(name, start, limit)
+	if err != nil {
+		cs.logger.Error("read history", zap.String("channel", name), zap.Error(err))
+		return nil
+	}
+	missed.records = records
+	if len(records) > 0 {
+		missed.upTo = max(missed.upTo, records[len(records)-1].Seq)
+	}
+	return missed
+}
+
+func (cs *channels) replayLimit() int {
+	if cs.cfg.History.ReplayLimit <= 0 {
+		return defaultReplayLimit
+	}
+	return cs.cfg.History.ReplayLimit
+}
+
+func (cs *channels) Get(name string) (*channel, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	ch, ok := cs.byName[name]
+	return ch, ok
+}
+
+func (cs *channels) All() []*channel {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	result := make([]*channel, 0, len(cs.byName))
+	for _, ch := range cs.byName {
+		result = append(result, ch)
+	}
+	return result
+}
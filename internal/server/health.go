@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/serg-pe/signals/internal/client"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusStarting = "starting"
+	healthStatusDraining = "draining"
+)
+
+// Version is reported by the health endpoints. Release builds set it with
+// -ldflags "-X github.com/serg-pe/signals/internal/server.Version=v1.2.3",
+// otherwise the module version from the build info is used.
+var Version = ""
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+type healthReport struct {
+	Status        string  `json:"status"`
+	Version       string  `json:"version"`
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Channels      int     `json:"channels"`
+	Publishers    int     `json:"publishers"`
+	Subscribers   int     `json:"subscribers"`
+}
+
+// healthz reports that the process is alive, it answers 200 until the process exits.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, http.StatusOK, healthStatusOK)
+}
+
+// readyz answers 200 while the listener is up and the server is not draining,
+// 503 otherwise, so the orchestrator stops routing clients here during shutdown.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.stateMu.RLock()
+	listening, stopping := s.listening, s.stopping
+	s.stateMu.RUnlock()
+
+	switch {
+	case stopping:
+		s.writeHealth(w, http.StatusServiceUnavailable, healthStatusDraining)
+	case !listening:
+		s.writeHealth(w, http.StatusServiceUnavailable, healthStatusStarting)
+	default:
+		s.writeHealth(w, http.StatusOK, healthStatusOK)
+	}
+}
+
+func (s *Server) writeHealth(w http.ResponseWriter, code int, status string) {
+	uptime := time.Since(s.startedAt)
+	report := healthReport{
+		Status:        status,
+		Version:       version(),
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: uptime.Seconds(),
+		Channels:      len(s.channels.All()),
+	}
+	for _, c := range s.clients.All() {
+		if c.Role() == client.RolePublisher {
+			report.Publishers++
+		} else {
+			report.Subscribers++
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, report)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/serg-pe/signals/internal/history"
+	"go.uber.org/zap"
+)
+
+const (
+	queryHistoryFrom  = "from"
+	queryHistoryTo    = "to"
+	queryHistoryLimit = "limit"
+
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+type historyResponse struct {
+	Channel string           `json:"channel"`
+	Records []history.Record `json:"records"`
+}
+
+// channelHistory returns the recorded state signals of a channel, oldest
+// first. from and to select a time range in RFC 3339 of which the newest
+// signals are returned, last-seq the oldest signals after a sequence number.
+// Credentials are checked like for a subscriber.
+func (s *Server) channelHistory(w http.ResponseWriter, r *http.Request) {
+	channelName := r.PathValue(pathChannelName)
+
+	if _, status := s.authorize(w, r, channelName, false); status != http.StatusOK {
+		return
+	}
+	if s.history == nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "history is disabled"})
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := parseHistoryTime(query.Get(queryHistoryFrom))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid from: " + err.Error()})
+		return
+	}
+	to, err := parseHistoryTime(query.Get(queryHistoryTo))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid to: " + err.Error()})
+		return
+	}
+	lastSeq, resume, err := parseLastSeq(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid " + queryLastSeqName + ": " + err.Error()})
+		return
+	}
+	if resume && (!from.IsZero() || !to.IsZero()) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: queryLastSeqName + " cannot be combined with a time range"})
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if raw := query.Get(queryHistoryLimit); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid limit"})
+			return
+		}
+		limit = min(limit, maxHistoryLimit)
+	}
+
+	var records []history.Record
+	if resume {
+		records, err = s.history.After(channelName, lastSeq, limit)
+	} else {
+		records, err = s.history.Range(channelName, from, to, limit)
+	}
+	if err != nil {
+		s.logger.Error("read history", zap.String("channel", channelName), zap.Error(err))
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "read history"})
+		return
+	}
+	if records == nil {
+		records = []history.Record{}
+	}
+
+	writeJSON(w, http.StatusOK, historyResponse{Channel: channelName, Records: records})
+}
+
+func parseHistoryTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
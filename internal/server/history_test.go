@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.ServerConfig{DrainTimeout: time.Millisecond * 200, History: config.HistoryConfig{Dir: t.TempDir()}}
+
+	// start runs a server with a publisher in the kitchen, the publisher sends the signals.
+	start := func() (Server, *httptest.Server, func(...signals.Signal)) {
+		s, err := New(cfg, config.AuthConfig{}, zap.NewNop())
+		require.NoError(t, err)
+		srv := httptest.NewServer(s.setupRoutes())
+		t.Cleanup(srv.Close)
+
+		pub, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/kitchen?is-initiator=true", nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { pub.Close() })
+
+		publish := func(sent ...signals.Signal) {
+			before := uint32(0)
+			if ch, ok := s.channels.Get("kitchen"); ok {
+				before, _, _ = ch.state()
+			}
+			for _, signal := range sent {
+				require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signal)}))
+			}
+			require.Eventually(t, func() bool {
+				ch, ok := s.channels.Get("kitchen")
+				if !ok {
+					return false
+				}
+				seq, _, _ := ch.state()
+				return seq == before+uint32(len(sent))
+			}, time.Second, time.Millisecond*10)
+		}
+		return s, srv, publish
+	}
+
+	query := func(srv *httptest.Server, path string) (int, historyResponse) {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		result := historyResponse{}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		}
+		return resp.StatusCode, result
+	}
+	seqs := func(result historyResponse) []uint32 {
+		records := make([]uint32, 0, len(result.Records))
+		for _, record := range result.Records {
+			records = append(records, record.Seq)
+		}
+		return records
+	}
+
+	s, srv, publish := start()
+	publish(signals.SignalOn, signals.SignalOff, signals.SignalOn)
+
+	code, result := query(srv, "/channels/kitchen/history")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "kitchen", result.Channel)
+	assert.Equal(t, []uint32{1, 2, 3}, seqs(result))
+	assert.Equal(t, signals.SignalOff, result.Records[1].Signal)
+	assert.Equal(t, anonymousIdentity, result.Records[1].Publisher)
+
+	code, result = query(srv, "/channels/kitchen/history?limit=2")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint32{2, 3}, seqs(result))
+
+	code, result = query(srv, "/channels/kitchen/history?last-seq=1&limit=1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint32{2}, seqs(result))
+
+	code, result = query(srv, "/channels/hall/history")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, result.Records)
+	assert.Empty(t, result.Records)
+
+	for _, path := range []string{
+		"/channels/kitchen/history?from=yesterday",
+		"/channels/kitchen/history?limit=0",
+		"/channels/kitchen/history?last-seq=1&from=2024-05-01T12:00:00Z",
+	} {
+		code, _ = query(srv, path)
+		assert.Equal(t, http.StatusBadRequest, code, path)
+	}
+
+	// A resuming subscriber gets the signals it missed, although none is retained.
+	sub, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/kitchen?last-seq=1", nil)
+	require.NoError(t, err)
+	defer sub.Close()
+	sub.SetReadDeadline(time.Now().Add(time.Second))
+	for _, expected := range []signals.Signal{signals.SignalPublisherConnected, signals.SignalOff, signals.SignalOn} {
+		_, msg, err := sub.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{byte(expected)}, msg)
+	}
+
+	sub.Close()
+	s.Stop(context.Background())
+
+	// Sequence numbers continue after a restart.
+	s, srv, publish = start()
+	defer s.Stop(context.Background())
+	publish(signals.SignalOff)
+	code, result = query(srv, "/channels/kitchen/history?last-seq=2")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint32{3, 4}, seqs(result))
+
+	disabled, err := New(config.ServerConfig{DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	disabledSrv := httptest.NewServer(disabled.setupRoutes())
+	defer disabledSrv.Close()
+	defer disabled.Stop(context.Background())
+	code, _ = query(disabledSrv, "/channels/kitchen/history")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHistoryReplayQueue(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.ServerConfig{
+		SendQueueSize:      4,
+		SlowConsumerPolicy: "disconnect",
+		DrainTimeout:       time.Millisecond * 200,
+		History:            config.HistoryConfig{Dir: t.TempDir()},
+	}
+	s, err := New(cfg, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	// Far more signals are missed than fit in the send queue.
+	expected := make([]signals.Signal, 0, 20)
+	for i := 0; i < 20; i++ {
+		signal := signals.SignalOn
+		if i%2 == 1 {
+			signal = signals.SignalOff
+		}
+		expected = append(expected, signal)
+		resp, err := http.Post(srv.URL+"/channels/kitchen/signal", "application/json", strings.NewReader(`{"signal":"`+signal.String()+`"}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	sub := dial(t, srv, "/connection/kitchen?last-seq=0")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+	for i, signal := range expected {
+		assert.Equal(t, signal, readSignal(t, sub), i)
+	}
+
+	// The subscriber is still connected and gets new signals.
+	resp, err := http.Post(srv.URL+"/channels/kitchen/signal", "application/json", strings.NewReader(`{"signal":"on"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, signals.SignalOn, readSignal(t, sub))
+}
+
+func TestHistoryRestoresRetained(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.ServerConfig{RetainLastSignal: true, DrainTimeout: time.Millisecond * 200, History: config.HistoryConfig{Dir: t.TempDir()}}
+	start := func() (Server, *httptest.Server) {
+		s, err := New(cfg, config.AuthConfig{}, zap.NewNop())
+		require.NoError(t, err)
+		srv := httptest.NewServer(s.setupRoutes())
+		t.Cleanup(srv.Close)
+		return s, srv
+	}
+
+	s, srv := start()
+	for _, signal := range []string{"on", "off"} {
+		resp, err := http.Post(srv.URL+"/channels/kitchen/signal", "application/json", strings.NewReader(`{"signal":"`+signal+`"}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	s.Stop(context.Background())
+
+	// The retained signal and the sequence numbers are restored from the history after a restart.
+	s, srv = start()
+	defer s.Stop(context.Background())
+	sub := dial(t, srv, "/connection/kitchen")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+	assert.Equal(t, signals.SignalOff, readSignal(t, sub))
+
+	ch, ok := s.channels.Get("kitchen")
+	require.True(t, ok)
+	seq, last, hasLast := ch.state()
+	assert.Equal(t, uint32(2), seq)
+	assert.True(t, hasLast)
+	assert.Equal(t, signals.Message{Signal: signals.SignalOff, Seq: 2}, last)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"time"
+
+	"github.com/serg-pe/signals/internal/client"
+	"github.com/serg-pe/signals/internal/metrics"
+	"github.com/serg-pe/signals/pkg/signals"
+)
+
+// Reasons of failed upgrades reported by signals_upgrade_failures_total.
+const (
+	upgradeNotWebsocket = "not_websocket"
+	upgradeBadRequest   = "bad_request"
+	upgradeUnauthorized = "unauthorized"
+	upgradeForbidden    = "forbidden"
+	upgradeOrigin       = "origin"
+	upgradeHandshake    = "handshake"
+	upgradeShutdown     = "shutdown"
+	upgradeLimit        = "limit"
+)
+
+// fanoutBuckets range from 50µs to 1s, relaying a signal usually takes microseconds.
+var fanoutBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	received        *metrics.CounterVec
+	delivered       *metrics.CounterVec
+	dropped         *metrics.Counter
+	upgradeFailures *metrics.CounterVec
+	fanout          *metrics.Histogram
+}
+
+func newServerMetrics(chs *channels) *serverMetrics {
+	r := metrics.NewRegistry()
+
+	r.GaugeFunc("signals_channel_publishers", "Connected publishers per channel.", []string{"channel"}, func(emit func(float64, ...string)) {
+		for _, ch := range chs.All() {
+			emit(float64(len(ch.Publishers())), ch.name)
+		}
+	})
+	r.GaugeFunc("signals_channel_subscribers", "Connected subscribers per channel.", []string{"channel"}, func(emit func(float64, ...string)) {
+		for _, ch := range chs.All() {
+			emit(float64(len(ch.Subscribers())), ch.name)
+		}
+	})
+
+	return &serverMetrics{
+		registry: r,
+
+		received:        r.CounterVec("signals_received_total", "Messages received from clients by signal.", "signal"),
+		delivered:       r.CounterVec("signals_delivered_total", "Messages written to clients by signal.", "signal"),
+		dropped:         r.Counter("signals_dropped_messages_total", "Messages discarded by the slow consumer policy."),
+		upgradeFailures: r.CounterVec("signals_upgrade_failures_total", "Connections refused before or during the websocket upgrade by reason.", "reason"),
+		fanout:          r.Histogram("signals_fanout_duration_seconds", "Time to relay a state signal to the send queues of all subscribers.", fanoutBuckets),
+	}
+}
+
+// clientHooks feeds the traffic of every client into the metrics.
+func (m *serverMetrics) clientHooks() client.Hooks {
+	return client.Hooks{
+		Received:  func(signal signals.Signal) { m.received.Inc(signal.String()) },
+		Delivered: func(signal signals.Signal) { m.delivered.Inc(signal.String()) },
+		Dropped:   m.dropped.Inc,
+	}
+}
+
+func (m *serverMetrics) upgradeFailed(reason string) {
+	m.upgradeFailures.Inc(reason)
+}
+
+func (m *serverMetrics) observeFanout(start time.Time) {
+	m.fanout.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// originPattern is an allowed origin such as "https://app.example.com",
+// "https://*.example.com" or "*.example.com" for any scheme.
+type originPattern struct {
+	scheme string
+	host   string
+	// wildcard matches subdomains of host, but not host itself.
+	wildcard bool
+}
+
+func parseOriginPattern(raw string) originPattern {
+	p := originPattern{}
+
+	raw = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(raw), "/"))
+	if scheme, host, ok := strings.Cut(raw, "://"); ok {
+		p.scheme = scheme
+		raw = host
+	}
+	if host, ok := strings.CutPrefix(raw, "*."); ok {
+		p.wildcard = true
+		raw = host
+	}
+	p.host = raw
+
+	return p
+}
+
+func (p originPattern) match(origin *url.URL) bool {
+	if p.scheme != "" && p.scheme != strings.ToLower(origin.Scheme) {
+		return false
+	}
+
+	host := strings.ToLower(origin.Host)
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+// originChecker implements websocket.Upgrader.CheckOrigin. Without patterns only
+// same host origins pass, "*" allows any origin. Requests without an Origin
+// header come from non-browser clients and always pass.
+type originChecker struct {
+	logger   *zap.Logger
+	allowAll bool
+	patterns []originPattern
+	onReject func()
+}
+
+func newOriginChecker(allowed []string, logger *zap.Logger, onReject func()) *originChecker {
+	c := &originChecker{
+		logger:   logger,
+		patterns: make([]originPattern, 0, len(allowed)),
+		onReject: onReject,
+	}
+
+	for _, raw := range allowed {
+		if strings.TrimSpace(raw) == "*" {
+			c.allowAll = true
+			continue
+		}
+		c.patterns = append(c.patterns, parseOriginPattern(raw))
+	}
+
+	return c
+}
+
+func (c *originChecker) check(r *http.Request) bool {
+	if c.allowed(r) {
+		return true
+	}
+
+	c.logger.Debug("origin rejected", zap.String("origin", r.Header.Get("Origin")), zap.String("client", r.RemoteAddr))
+	if c.onReject != nil {
+		c.onReject()
+	}
+	return false
+}
+
+func (c *originChecker) allowed(r *http.Request) bool {
+	rawOrigin := r.Header.Get("Origin")
+	if rawOrigin == "" || c.allowAll {
+		return true
+	}
+
+	origin, err := url.Parse(rawOrigin)
+	if err != nil || origin.Host == "" {
+		return false
+	}
+
+	if len(c.patterns) == 0 {
+		return strings.EqualFold(origin.Host, r.Host)
+	}
+
+	for _, p := range c.patterns {
+		if p.match(origin) {
+			return true
+		}
+	}
+	return false
+}
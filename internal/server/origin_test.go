@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestOriginChecker(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		allowed  []string
+		origin   string
+		expected bool
+	}{
+		{
+			name:     "no origin header",
+			allowed:  []string{"https://app.example.com"},
+			origin:   "",
+			expected: true,
+		},
+		{
+			name:     "same host by default",
+			allowed:  nil,
+			origin:   "http://signals.local:8000",
+			expected: true,
+		},
+		{
+			name:     "foreign host by default",
+			allowed:  nil,
+			origin:   "https://evil.com",
+			expected: false,
+		},
+		{
+			name:     "exact match",
+			allowed:  []string{"https://app.example.com"},
+			origin:   "https://APP.example.com",
+			expected: true,
+		},
+		{
+			name:     "scheme mismatch",
+			allowed:  []string{"https://app.example.com"},
+			origin:   "http://app.example.com",
+			expected: false,
+		},
+		{
+			name:     "wildcard subdomain",
+			allowed:  []string{"https://*.example.com"},
+			origin:   "https://a.b.example.com",
+			expected: true,
+		},
+		{
+			name:     "wildcard does not match apex",
+			allowed:  []string{"https://*.example.com"},
+			origin:   "https://example.com",
+			expected: false,
+		},
+		{
+			name:     "wildcard does not match suffix",
+			allowed:  []string{"*.example.com"},
+			origin:   "https://evilexample.com",
+			expected: false,
+		},
+		{
+			name:     "wildcard any scheme",
+			allowed:  []string{"*.example.com"},
+			origin:   "http://app.example.com",
+			expected: true,
+		},
+		{
+			name:     "port must match",
+			allowed:  []string{"http://localhost:3000"},
+			origin:   "http://localhost:3001",
+			expected: false,
+		},
+		{
+			name:     "allow all",
+			allowed:  []string{"*"},
+			origin:   "https://evil.com",
+			expected: true,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rejected := 0
+			checker := newOriginChecker(tc.allowed, zap.NewNop(), func() { rejected++ })
+
+			r := httptest.NewRequest("GET", "http://signals.local:8000/connection/a", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+
+			assert.Equal(t, tc.expected, checker.check(r))
+			assert.Equal(t, !tc.expected, rejected == 1)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxPublishBodyLen leaves room for the base64 payload of a JSON message.
+	maxPublishBodyLen = signals.MaxMessageLen * 2
+
+	contentTypeJSON = "application/json"
+)
+
+var errNotStateSignal = errors.New("only on and off can be published")
+
+type publishResponse struct {
+	Channel string         `json:"channel"`
+	Signal  signals.Signal `json:"signal"`
+	publishResult
+}
+
+// publishHTTP relays a signal posted by a client that cannot hold a websocket
+// open. The body is a JSON message or a binary one, a single byte in the legacy
+// format or a v1 frame. Credentials are checked like for a websocket publisher.
+func (s *Server) publishHTTP(w http.ResponseWriter, r *http.Request) {
+	channelName := r.PathValue(pathChannelName)
+
+	identity, status := s.authorize(w, r, channelName, true)
+	if status != http.StatusOK {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPublishBodyLen))
+	if err != nil {
+		code := http.StatusBadRequest
+		if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeJSON(w, code, apiError{Error: err.Error()})
+		return
+	}
+
+	msg, err := decodePublishBody(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		s.logger.Debug("decode published signal", zap.String("client", r.RemoteAddr), zap.Error(err))
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	s.metrics.received.Inc(msg.Signal.String())
+
+	result := s.publish(channelName, msg.Signal, identity.Name)
+	writeJSON(w, http.StatusOK, publishResponse{Channel: channelName, Signal: msg.Signal, publishResult: result})
+}
+
+func decodePublishBody(contentType string, body []byte) (signals.Message, error) {
+	var (
+		msg signals.Message
+		err error
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == contentTypeJSON:
+		msg, err = signals.DecodeJSON(body)
+	case len(body) == 1:
+		msg, err = signals.Decode(signals.VersionLegacy, body)
+	default:
+		msg, err = signals.Decode(signals.Version1, body)
+	}
+	if err != nil {
+		return msg, err
+	}
+
+	if msg.Signal != signals.SignalOn && msg.Signal != signals.SignalOff {
+		return msg, errNotStateSignal
+	}
+	return msg, nil
+}
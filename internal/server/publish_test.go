@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPublishHTTP(t *testing.T) {
+	t.Parallel()
+
+	authCfg := config.AuthConfig{
+		Enabled: true,
+		Tokens: []config.TokenConfig{
+			{Name: "cron", Token: "cron-secret", Role: "publish", Channels: []string{"kitchen"}},
+			{Name: "screen", Token: "screen-secret", Role: "subscribe"},
+		},
+	}
+	s, err := New(config.ServerConfig{}, authCfg, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	sub, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/kitchen?token=screen-secret", nil)
+	require.NoError(t, err)
+	defer sub.Close()
+	_, _, err = sub.ReadMessage()
+	require.NoError(t, err)
+
+	post := func(channel, token, contentType string, body []byte) (int, publishResponse) {
+		req, err := http.NewRequest("POST", srv.URL+"/channels/"+channel+"/signal", strings.NewReader(string(body)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		result := publishResponse{}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		}
+		return resp.StatusCode, result
+	}
+
+	v1, err := signals.Encode(signals.Version1, signals.Message{Signal: signals.SignalOff})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		channel      string
+		token        string
+		contentType  string
+		body         []byte
+		expectedCode int
+		expected     signals.Signal
+	}{
+		{name: "json", channel: "kitchen", token: "cron-secret", contentType: "application/json", body: []byte(`{"signal":"on"}`), expectedCode: http.StatusOK, expected: signals.SignalOn},
+		{name: "legacy", channel: "kitchen", token: "cron-secret", contentType: "application/octet-stream", body: []byte{byte(signals.SignalOff)}, expectedCode: http.StatusOK, expected: signals.SignalOff},
+		{name: "v1", channel: "kitchen", token: "cron-secret", contentType: "application/octet-stream", body: v1, expectedCode: http.StatusOK, expected: signals.SignalOff},
+		{name: "not a state signal", channel: "kitchen", token: "cron-secret", contentType: "application/json", body: []byte(`{"signal":"ping"}`), expectedCode: http.StatusBadRequest},
+		{name: "json without signal", channel: "kitchen", token: "cron-secret", contentType: "application/json", body: []byte(`{"seq":3}`), expectedCode: http.StatusBadRequest},
+		{name: "empty json", channel: "kitchen", token: "cron-secret", contentType: "application/json", body: []byte(`{}`), expectedCode: http.StatusBadRequest},
+		{name: "empty body", channel: "kitchen", token: "cron-secret", contentType: "application/octet-stream", body: nil, expectedCode: http.StatusBadRequest},
+		{name: "no token", channel: "kitchen", token: "", contentType: "application/json", body: []byte(`{"signal":"on"}`), expectedCode: http.StatusUnauthorized},
+		{name: "subscriber token", channel: "kitchen", token: "screen-secret", contentType: "application/json", body: []byte(`{"signal":"on"}`), expectedCode: http.StatusForbidden},
+		{name: "other channel", channel: "hall", token: "cron-secret", contentType: "application/json", body: []byte(`{"signal":"on"}`), expectedCode: http.StatusForbidden},
+	}
+
+	seq := uint32(0)
+	for _, tc := range tests {
+		code, result := post(tc.channel, tc.token, tc.contentType, tc.body)
+		require.Equal(t, tc.expectedCode, code, tc.name)
+		if code != http.StatusOK {
+			continue
+		}
+
+		seq++
+		assert.Equal(t, publishResponse{
+			Channel:       tc.channel,
+			Signal:        tc.expected,
+			publishResult: publishResult{Seq: seq, Subscribers: 1, Delivered: 1},
+		}, result, tc.name)
+
+		sub.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := sub.ReadMessage()
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, []byte{byte(tc.expected)}, msg, tc.name)
+	}
+}
+
+func TestPublishHTTPWithoutClients(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{RetainLastSignal: true, DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	post := func(signal string) publishResponse {
+		resp, err := http.Post(srv.URL+"/channels/kitchen/signal", "application/json", strings.NewReader(`{"signal":"`+signal+`"}`))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		result := publishResponse{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	assert.Equal(t, publishResult{Seq: 1}, post("on").publishResult)
+	assert.Equal(t, publishResult{Seq: 2}, post("off").publishResult)
+	_, ok := s.channels.Get("kitchen")
+	assert.False(t, ok)
+
+	// The last signal is retained for subscribers that join later.
+	sub := dial(t, srv, "/connection/kitchen")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+	assert.Equal(t, signals.SignalOff, readSignal(t, sub))
+
+	assert.Equal(t, publishResult{Seq: 3, Subscribers: 1, Delivered: 1}, post("on").publishResult)
+	assert.Equal(t, signals.SignalOn, readSignal(t, sub))
+}
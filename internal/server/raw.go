@@ -0,0 +1,389 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/internal/auth"
+	"github.com/serg-pe/signals/internal/client"
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	// helloPrefix starts the first frame of a TCP connection and the first
+	// datagram of a UDP session, the rest is a query string like the one of a
+	// websocket url: channel, role, protocol, token and last-seq.
+	helloPrefix = "hello?"
+
+	helloQueryChannel  = "channel"
+	helloQueryRole     = "role"
+	helloQueryProtocol = "protocol"
+
+	helloTimeout = time.Second * 10
+
+	defaultUDPSessionTimeout = time.Minute
+	defaultUDPMaxSessions    = 1024
+)
+
+var errBadHello = errors.New("bad hello")
+
+// hello introduces a device on a plain TCP connection or UDP socket.
+type hello struct {
+	channel  string
+	isPub    bool
+	protocol string
+	lastSeq  uint32
+	resume   bool
+	query    url.Values
+}
+
+func parseHello(data []byte) (hello, error) {
+	raw, ok := bytes.CutPrefix(data, []byte(helloPrefix))
+	if !ok {
+		return hello{}, fmt.Errorf("%w: missing %q prefix", errBadHello, helloPrefix)
+	}
+	query, err := url.ParseQuery(string(raw))
+	if err != nil {
+		return hello{}, fmt.Errorf("%w: %w", errBadHello, err)
+	}
+
+	h := hello{
+		channel:  query.Get(helloQueryChannel),
+		protocol: query.Get(helloQueryProtocol),
+		query:    query,
+	}
+	if h.channel == "" {
+		h.channel = defaultChannelName
+	}
+
+	switch role := query.Get(helloQueryRole); role {
+	case "", client.RoleSubscriber.String():
+	case client.RolePublisher.String():
+		h.isPub = true
+	default:
+		return hello{}, fmt.Errorf("%w: unknown role %q", errBadHello, role)
+	}
+
+	h.lastSeq, h.resume, err = parseLastSeq(query)
+	if err != nil {
+		return hello{}, fmt.Errorf("%w: %w", errBadHello, err)
+	}
+
+	if h.protocol != signals.SubprotocolJSON {
+		if _, err := signals.VersionFromSubprotocol(h.protocol); err != nil {
+			return hello{}, fmt.Errorf("%w: %w", errBadHello, err)
+		}
+	}
+
+	return h, nil
+}
+
+func (h hello) role() client.Role {
+	if h.isPub {
+		return client.RolePublisher
+	}
+	return client.RoleSubscriber
+}
+
+// request lets the authenticators check the token of the hello like the one of a websocket url.
+func (h hello) request(remoteAddr string) *http.Request {
+	return &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{RawQuery: h.query.Encode()},
+		Header:     http.Header{},
+		RemoteAddr: remoteAddr,
+	}
+}
+
+// rawListeners are the plain TCP and UDP listeners, Stop closes them.
+type rawListeners struct {
+	mu      *sync.Mutex
+	tcp     net.Listener
+	udp     net.PacketConn
+	udpDone chan struct{}
+}
+
+func newRawListeners() *rawListeners {
+	return &rawListeners{mu: &sync.Mutex{}}
+}
+
+// closeTCP stops accepting connections, established ones are drained like websockets.
+func (l *rawListeners) closeTCP() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tcp != nil {
+		l.tcp.Close()
+	}
+}
+
+// closeUDP closes the socket shared by every UDP session and waits for its reader.
+func (l *rawListeners) closeUDP() {
+	l.mu.Lock()
+	pc, done := l.udp, l.udpDone
+	l.mu.Unlock()
+
+	if pc == nil {
+		return
+	}
+	pc.Close()
+	<-done
+}
+
+// accept checks the hello of a device, it returns the identity to serve the
+// device as and false if the device is rejected.
+func (s *Server) accept(data []byte, remoteAddr string) (hello, auth.Identity, bool) {
+	h, err := parseHello(data)
+	if err != nil {
+		s.logger.Debug("parse hello", zap.String("client", remoteAddr), zap.Error(err))
+		s.metrics.upgradeFailed(upgradeBadRequest)
+		return h, auth.Identity{}, false
+	}
+
+	identity, err := s.identify(h.request(remoteAddr), h.channel, h.isPub)
+	switch {
+	case errors.Is(err, errAccessDenied):
+		s.metrics.upgradeFailed(upgradeForbidden)
+		return h, identity, false
+	case err != nil:
+		s.metrics.upgradeFailed(upgradeUnauthorized)
+		return h, identity, false
+	}
+
+	return h, identity, true
+}
+
+// serveTCP accepts devices that send length prefixed frames, see
+// client.ReadFrame, until the listener is closed. The first frame is a hello.
+func (s *Server) serveTCP(listener net.Listener) error {
+	s.raw.mu.Lock()
+	s.raw.tcp = listener
+	s.raw.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if !s.track() {
+			s.metrics.upgradeFailed(upgradeShutdown)
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.wg.Done()
+			s.serveTCPConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveTCPConn(conn net.Conn) {
+	remoteAddr := conn.RemoteAddr().String()
+
+	if err := conn.SetReadDeadline(time.Now().Add(helloTimeout)); err != nil {
+		s.logger.Debug("set hello deadline", zap.String("client", remoteAddr), zap.Error(err))
+		conn.Close()
+		return
+	}
+	data, err := client.ReadFrame(conn, signals.MaxMessageLen)
+	if err != nil {
+		s.logger.Debug("read hello", zap.String("client", remoteAddr), zap.Error(err))
+		s.metrics.upgradeFailed(upgradeBadRequest)
+		conn.Close()
+		return
+	}
+	h, identity, ok := s.accept(data, remoteAddr)
+	if !ok {
+		conn.Close()
+		return
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		s.logger.Debug("reset hello deadline", zap.String("client", remoteAddr), zap.Error(err))
+		conn.Close()
+		return
+	}
+
+	c := client.New(s.logger, client.NewTCP(conn, h.protocol), s.clientOptions(), h.channel, h.role(), s.onSignal)
+	c.SetIdentity(identity.Name)
+	if h.resume {
+		c.SetResumeAfter(h.lastSeq)
+	}
+	s.register(c)
+	s.serve(c)
+}
+
+// serveUDP reads datagrams until the socket is closed. A hello datagram
+// starts a session for its sender, replacing an earlier one; every other
+// datagram is a message of the session of its sender.
+func (s *Server) serveUDP(pc net.PacketConn) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	s.raw.mu.Lock()
+	s.raw.udp, s.raw.udpDone = pc, done
+	s.raw.mu.Unlock()
+
+	maxSessions := s.cfg.UDPMaxSessions
+	if maxSessions <= 0 {
+		maxSessions = defaultUDPMaxSessions
+	}
+	sessions := &udpSessions{mu: &sync.Mutex{}, byAddr: make(map[string]*client.UDPTransport), max: maxSessions}
+	defer sessions.closeAll()
+
+	buf := make([]byte, signals.MaxMessageLen)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		data := buf[:n]
+
+		if bytes.HasPrefix(data, []byte(helloPrefix)) {
+			s.udpHello(pc, addr, data, sessions)
+			continue
+		}
+		if session, ok := sessions.get(addr.String()); ok {
+			if !session.Deliver(data) {
+				s.logger.Debug("udp session is busy, datagram dropped", zap.String("client", addr.String()))
+			}
+		}
+	}
+}
+
+func (s *Server) udpHello(pc net.PacketConn, addr net.Addr, data []byte, sessions *udpSessions) {
+	h, identity, ok := s.accept(data, addr.String())
+	if !ok {
+		return
+	}
+
+	// Every session holds a client for the session timeout, so spoofed hellos must not pile up.
+	session := client.NewUDP(pc, addr, h.protocol)
+	if !sessions.replace(addr.String(), session) {
+		s.logger.Debug("too many udp sessions, hello dropped", zap.String("client", addr.String()))
+		s.metrics.upgradeFailed(upgradeLimit)
+		return
+	}
+	if !s.track() {
+		sessions.remove(addr.String(), session)
+		session.Close()
+		s.metrics.upgradeFailed(upgradeShutdown)
+		return
+	}
+
+	opts := s.clientOptions()
+	// Datagrams cannot answer pings, sessions end once the device goes quiet.
+	opts.IdleTimeout = s.cfg.UDPSessionTimeout
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultUDPSessionTimeout
+	}
+
+	c := client.New(s.logger, session, opts, h.channel, h.role(), s.onSignal)
+	c.SetIdentity(identity.Name)
+	// The sender address of a hello may be forged, so a session never gets
+	// more than the retained signal instead of a replay sent to a victim.
+	if h.resume {
+		s.logger.Debug("last-seq is ignored over udp", zap.String("client", addr.String()))
+	}
+
+	// Joining may read the history, so it happens off the reader.
+	go func() {
+		defer s.wg.Done()
+		s.register(c)
+		s.serve(c)
+		sessions.remove(addr.String(), session)
+	}()
+}
+
+// udpSessions maps the address of a device to its session, at most max of them.
+type udpSessions struct {
+	mu     *sync.Mutex
+	byAddr map[string]*client.UDPTransport
+	max    int
+}
+
+func (u *udpSessions) get(addr string) (*client.UDPTransport, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	session, ok := u.byAddr[addr]
+	return session, ok
+}
+
+// replace ends the previous session of a device that said hello again, e.g.
+// after a reboot. It reports false if a new device would exceed the limit.
+func (u *udpSessions) replace(addr string, session *client.UDPTransport) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	old, ok := u.byAddr[addr]
+	if !ok && len(u.byAddr) >= u.max {
+		return false
+	}
+	if ok {
+		old.Close()
+	}
+	u.byAddr[addr] = session
+	return true
+}
+
+// remove forgets the session unless it has been replaced already.
+func (u *udpSessions) remove(addr string, session *client.UDPTransport) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.byAddr[addr] == session {
+		delete(u.byAddr, addr)
+	}
+}
+
+func (u *udpSessions) closeAll() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, session := range u.byAddr {
+		session.Close()
+	}
+}
+
+// listenRaw binds the plain TCP and UDP listeners that are enabled in the config.
+func (s *Server) listenRaw() (net.Listener, net.PacketConn, error) {
+	var (
+		tcp net.Listener
+		udp net.PacketConn
+		err error
+	)
+	if s.cfg.TCPPort != 0 {
+		// Devices do not answer keep-alive frames, TCP keep-alives find dead ones.
+		lc := net.ListenConfig{KeepAlive: s.cfg.PingInterval}
+		tcp, err = lc.Listen(context.Background(), "tcp", net.JoinHostPort(s.cfg.Ip, strconv.Itoa(int(s.cfg.TCPPort))))
+		if err != nil {
+			return nil, nil, fmt.Errorf("listen tcp: %w", err)
+		}
+	}
+	if s.cfg.UDPPort != 0 {
+		udp, err = net.ListenPacket("udp", net.JoinHostPort(s.cfg.Ip, strconv.Itoa(int(s.cfg.UDPPort))))
+		if err != nil {
+			if tcp != nil {
+				tcp.Close()
+			}
+			return nil, nil, fmt.Errorf("listen udp: %w", err)
+		}
+	}
+	return tcp, udp, nil
+}
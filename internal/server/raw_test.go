@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/serg-pe/signals/internal/client"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseHello(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		data     string
+		expected hello
+		err      bool
+	}{
+		{
+			name:     "defaults",
+			data:     "hello?",
+			expected: hello{channel: defaultChannelName},
+		},
+		{
+			name:     "publisher",
+			data:     "hello?channel=kitchen&role=publisher&protocol=signals.v1",
+			expected: hello{channel: "kitchen", isPub: true, protocol: "signals.v1"},
+		},
+		{
+			name:     "json",
+			data:     "hello?role=subscriber&protocol=signals.json",
+			expected: hello{channel: defaultChannelName, protocol: signals.SubprotocolJSON},
+		},
+		{name: "no prefix", data: "channel=kitchen", err: true},
+		{name: "unknown role", data: "hello?role=admin", err: true},
+		{name: "unknown protocol", data: "hello?protocol=signals.v9", err: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			h, err := parseHello([]byte(tc.data))
+			if tc.err {
+				assert.ErrorIs(t, err, errBadHello)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected.channel, h.channel)
+			assert.Equal(t, tc.expected.isPub, h.isPub)
+			assert.Equal(t, tc.expected.protocol, h.protocol)
+		})
+	}
+}
+
+func TestRawTransports(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.serveTCP(tcpListener)
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.serveUDP(udpConn)
+
+	sub, err := net.Dial("tcp", tcpListener.Addr().String())
+	require.NoError(t, err)
+	defer sub.Close()
+	require.NoError(t, client.WriteFrame(sub, []byte("hello?channel=kitchen")))
+	require.NoError(t, sub.SetReadDeadline(time.Now().Add(time.Second*5)))
+
+	read := func() signals.Signal {
+		data, err := client.ReadFrame(sub, signals.MaxMessageLen)
+		require.NoError(t, err)
+		require.Len(t, data, 1)
+		return signals.Signal(data[0])
+	}
+	assert.Equal(t, signals.SignalPublisherDisconnected, read())
+
+	pub, err := net.Dial("udp", udpConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer pub.Close()
+	_, err = pub.Write([]byte("hello?channel=kitchen&role=publisher&protocol=signals.json"))
+	require.NoError(t, err)
+	assert.Equal(t, signals.SignalPublisherConnected, read())
+
+	_, err = pub.Write([]byte(`{"signal":"on"}`))
+	require.NoError(t, err)
+	assert.Equal(t, signals.SignalOn, read())
+
+	// The publisher hears about the subscriber and its acknowledgements.
+	require.NoError(t, pub.SetReadDeadline(time.Now().Add(time.Second*5)))
+	buf := make([]byte, signals.MaxMessageLen)
+	n, err := pub.Read(buf)
+	require.NoError(t, err)
+	msg, err := signals.DecodeJSON(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, signals.SignalUpdateSubscribersStatistic, msg.Signal)
+
+	var transports []string
+	for _, c := range s.clients.All() {
+		transports = append(transports, c.Transport())
+	}
+	assert.ElementsMatch(t, []string{"tcp", "udp"}, transports)
+
+	s.Stop(context.Background())
+	assert.Equal(t, 0, s.clients.Len())
+
+	// The server half-closes TCP connections on shutdown.
+	_, err = client.ReadFrame(sub, signals.MaxMessageLen)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestUDPIgnoresLastSeq(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.ServerConfig{RetainLastSignal: true, DrainTimeout: time.Millisecond * 200, History: config.HistoryConfig{Dir: t.TempDir()}}
+	s, err := New(cfg, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.serveUDP(udpConn)
+
+	for _, signal := range []signals.Signal{signals.SignalOn, signals.SignalOff, signals.SignalOn} {
+		s.channels.Publish("kitchen", signal, anonymousIdentity)
+	}
+
+	// A forged hello must not turn the server into an amplifier, the session gets just the retained signal.
+	sub, err := net.Dial("udp", udpConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer sub.Close()
+	_, err = sub.Write([]byte("hello?channel=kitchen&protocol=signals.json&last-seq=0"))
+	require.NoError(t, err)
+
+	buf := make([]byte, signals.MaxMessageLen)
+	read := func() (signals.Message, error) {
+		require.NoError(t, sub.SetReadDeadline(time.Now().Add(time.Millisecond*500)))
+		n, err := sub.Read(buf)
+		if err != nil {
+			return signals.Message{}, err
+		}
+		return signals.DecodeJSON(buf[:n])
+	}
+	msg, err := read()
+	require.NoError(t, err)
+	assert.Equal(t, signals.SignalPublisherDisconnected, msg.Signal)
+	msg, err = read()
+	require.NoError(t, err)
+	assert.Equal(t, signals.Message{Signal: signals.SignalOn, Seq: 3}, msg)
+	_, err = read()
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "unexpected datagram: %v", err)
+}
+
+func TestUDPMaxSessions(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{UDPMaxSessions: 1, DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.serveUDP(udpConn)
+
+	// hello reports whether the sender got the publisher presence, i.e. a session.
+	buf := make([]byte, signals.MaxMessageLen)
+	hello := func(conn net.Conn) bool {
+		_, err := conn.Write([]byte("hello?channel=kitchen"))
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Millisecond*300)))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return false
+		}
+		assert.Equal(t, []byte{byte(signals.SignalPublisherDisconnected)}, buf[:n])
+		return true
+	}
+
+	first, err := net.Dial("udp", udpConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := net.Dial("udp", udpConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer second.Close()
+
+	assert.True(t, hello(first))
+	assert.False(t, hello(second))
+	// A device saying hello again replaces its own session.
+	assert.True(t, hello(first))
+	assert.Eventually(t, func() bool { return s.clients.Len() == 1 }, time.Second, time.Millisecond*10)
+}
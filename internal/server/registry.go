@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/serg-pe/signals/internal/client"
+	"github.com/serg-pe/signals/pkg/types/storages/array"
+)
+
+const (
+	registryInitialCapacity = 64
+)
+
+var errUnknownClient = errors.New("unknown client")
+
+// registry keeps every live client and hands out stable ids for them. Ids
+// grow monotonically and are never reused, so a stale id cannot reach a
+// client that connected later into the same storage slot.
+type registry struct {
+	mu      *sync.RWMutex
+	clients array.ArrayStorage[*client.Client]
+	// slots maps client ids to their index in clients.
+	slots  map[int]int
+	lastID int
+}
+
+func newRegistry() *registry {
+	return &registry{
+		mu:      &sync.RWMutex{},
+		clients: array.New[*client.Client](registryInitialCapacity),
+		slots:   make(map[int]int),
+	}
+}
+
+func (r *registry) Add(c *client.Client) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	id := r.lastID
+	r.slots[id] = r.clients.Add(c)
+	c.SetID(id)
+	return id
+}
+
+func (r *registry) Remove(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	slot, ok := r.slots[id]
+	if !ok {
+		return errUnknownClient
+	}
+	delete(r.slots, id)
+	return r.clients.Remove(slot)
+}
+
+func (r *registry) Get(id int) (*client.Client, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	slot, ok := r.slots[id]
+	if !ok {
+		return nil, errUnknownClient
+	}
+	return r.clients.Get(slot)
+}
+
+func (r *registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.clients.Len()
+}
+
+// Select returns a snapshot of the clients matching filter, so callers may
+// talk to them without holding the registry lock.
+func (r *registry) Select(filter func(c *client.Client) bool) []*client.Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*client.Client, 0)
+	r.clients.ApplyToAll(func(c *client.Client) {
+		if filter(c) {
+			result = append(result, c)
+		}
+	})
+	return result
+}
+
+func (r *registry) All() []*client.Client {
+	return r.Select(func(c *client.Client) bool { return true })
+}
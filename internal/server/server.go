@@ -3,19 +3,31 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/auth"
 	"github.com/serg-pe/signals/internal/client"
 	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/internal/history"
+	"github.com/serg-pe/signals/internal/webhook"
+	"github.com/serg-pe/signals/pkg/signals"
 	"go.uber.org/zap"
 )
 
 const (
 	queryIsPublisherName = "is-initiator"
+	// queryLastSeqName carries the sequence number of the last state signal a
+	// reconnecting client has seen, missed signals are replayed.
+	queryLastSeqName = "last-seq"
+	pathChannelName  = "channel"
+
+	defaultDrainTimeout = time.Second * 5
 )
 
 type Server struct {
@@ -25,13 +37,33 @@ type Server struct {
 	server *http.Server
 
 	upgrader websocket.Upgrader
+	origins  *originChecker
+	metrics  *serverMetrics
+
+	auth  auth.Authenticator
+	jwt   *auth.JWT
+	admin auth.Authenticator
 
-	client *client.Client
+	tls      *tlsReloader
+	raw      *rawListeners
+	bridge   *mqttBridge
+	webhooks *webhook.Dispatcher
+	history  *history.Store
 
-	wg *sync.WaitGroup
+	clients      *registry
+	channels     *channels
+	slowConsumer client.SlowConsumerPolicy
+
+	startedAt time.Time
+	// listening is set once Run binds the listener, stopping refuses new
+	// connections once Stop begins, see track.
+	stateMu   *sync.RWMutex
+	listening bool
+	stopping  bool
+	wg        *sync.WaitGroup
 }
 
-func New(cfg config.ServerConfig, logger *zap.Logger) (Server, error) {
+func New(cfg config.ServerConfig, authCfg config.AuthConfig, logger *zap.Logger) (Server, error) {
 	s := Server{
 		logger: logger.Named("server"),
 		cfg:    cfg,
@@ -45,10 +77,75 @@ func New(cfg config.ServerConfig, logger *zap.Logger) (Server, error) {
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferPool: &sync.Pool{},
-			CheckOrigin:     func(r *http.Request) bool { return true },
+			Subprotocols:    append(signals.Subprotocols(), signals.SubprotocolJSON),
 		},
 
-		wg: &sync.WaitGroup{},
+		clients: newRegistry(),
+		raw:     newRawListeners(),
+
+		startedAt: time.Now(),
+		stateMu:   &sync.RWMutex{},
+		wg:        &sync.WaitGroup{},
+	}
+
+	if cfg.History.Dir != "" {
+		store, err := history.Open(history.Options{
+			Dir:         cfg.History.Dir,
+			SegmentSize: cfg.History.SegmentSize,
+			MaxAge:      cfg.History.MaxAge,
+			MaxSize:     cfg.History.MaxSize,
+		}, s.logger.Named("history"))
+		if err != nil {
+			return s, fmt.Errorf("init history: %w", err)
+		}
+		s.history = store
+	}
+	s.channels = newChannels(cfg, logger.Named("channels"), s.observe, s.history)
+	s.metrics = newServerMetrics(s.channels)
+	s.origins = newOriginChecker(
+		cfg.AllowedOrigins,
+		s.logger.Named("origin"),
+		func() { s.metrics.upgradeFailed(upgradeOrigin) },
+	)
+	s.upgrader.CheckOrigin = s.origins.check
+
+	policy, err := client.ParseSlowConsumerPolicy(cfg.SlowConsumerPolicy)
+	if err != nil {
+		return s, err
+	}
+	s.slowConsumer = policy
+
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		reloader, err := newTLSReloader(cfg, s.logger.Named("tls"))
+		if err != nil {
+			return s, fmt.Errorf("init tls: %w", err)
+		}
+		s.tls = reloader
+		s.server.TLSConfig = reloader.TLSConfig()
+	}
+
+	if authCfg.Enabled {
+		if err := s.setupAuth(authCfg); err != nil {
+			return s, err
+		}
+	}
+
+	if err := s.setupAdmin(authCfg.Admin); err != nil {
+		return s, err
+	}
+
+	if len(cfg.Webhooks.Hooks) > 0 {
+		dispatcher, err := webhook.New(cfg.Webhooks, s.logger.Named("webhook"))
+		if err != nil {
+			return s, fmt.Errorf("init webhooks: %w", err)
+		}
+		s.webhooks = dispatcher
+	}
+
+	if cfg.MQTT.Broker != "" {
+		if err := s.setupMQTT(cfg.MQTT); err != nil {
+			return s, err
+		}
 	}
 
 	return s, nil
@@ -57,7 +154,15 @@ func New(cfg config.ServerConfig, logger *zap.Logger) (Server, error) {
 func (s *Server) setupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/connection/", s.connect)
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.Handle("GET /metrics", s.metrics.registry)
+	mux.HandleFunc("/connection/{$}", s.connect)
+	mux.HandleFunc("/connection/{"+pathChannelName+"}", s.connect)
+	mux.HandleFunc("POST /channels/{"+pathChannelName+"}/signal", s.publishHTTP)
+	mux.HandleFunc("GET /channels/{"+pathChannelName+"}/events", s.events)
+	mux.HandleFunc("GET /channels/{"+pathChannelName+"}/history", s.channelHistory)
+	s.setupAdminRoutes(mux)
 
 	return mux
 }
@@ -70,58 +175,305 @@ func (s *Server) connect(w http.ResponseWriter, r *http.Request) {
 
 	if !websocket.IsWebSocketUpgrade(r) {
 		s.logger.Debug("no upgrade protocol header", zap.String("from", r.RemoteAddr))
+		s.metrics.upgradeFailed(upgradeNotWebsocket)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	channelName := r.PathValue(pathChannelName)
+	if channelName == "" {
+		channelName = defaultChannelName
+	}
+
 	if r.URL.Query().Has(queryIsPublisherName) {
 		isPubRaw := r.URL.Query().Get(queryIsPublisherName)
 		isPub, err = strconv.ParseBool(isPubRaw)
 		if err != nil {
 			s.logger.Debug("parse bool error", zap.String("client", r.RemoteAddr), zap.String("value", isPubRaw))
+			s.metrics.upgradeFailed(upgradeBadRequest)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 	}
 
+	lastSeq, resume, err := parseLastSeq(r.URL.Query())
+	if err != nil {
+		s.logger.Debug("parse last seq", zap.String("client", r.RemoteAddr), zap.Error(err))
+		s.metrics.upgradeFailed(upgradeBadRequest)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	identity, status := s.authorize(w, r, channelName, isPub)
+	switch status {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		s.metrics.upgradeFailed(upgradeUnauthorized)
+		return
+	default:
+		s.metrics.upgradeFailed(upgradeForbidden)
+		return
+	}
+
+	if !s.track() {
+		s.metrics.upgradeFailed(upgradeShutdown)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		s.logger.Error("upgrade connection", zap.String("client", r.RemoteAddr), zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		s.wg.Done()
+		// Upgrade has already replied with an http error. Origin rejections are counted by the checker.
+		s.logger.Debug("upgrade connection", zap.String("client", r.RemoteAddr), zap.Error(err))
+		if s.origins.allowed(r) {
+			s.metrics.upgradeFailed(upgradeHandshake)
+		}
 		return
 	}
 
-	if !isPub {
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
-			s.logger.Info("subscriber connected", zap.String("address", conn.RemoteAddr().String()))
-			s.client = client.New(s.logger.Named(fmt.Sprintf("client %s", conn.RemoteAddr().String())), conn)
-			go s.client.Listen()
-		}()
+	role := client.RoleSubscriber
+	if isPub {
+		role = client.RolePublisher
+	}
+
+	c := client.New(s.logger, client.NewWebsocket(conn), s.clientOptions(), channelName, role, s.onSignal)
+	c.SetIdentity(identity.Name)
+	if resume {
+		c.SetResumeAfter(lastSeq)
+	}
+	s.register(c)
+
+	go func() {
+		defer s.wg.Done()
+		s.serve(c)
+	}()
+}
+
+// parseLastSeq returns the value of queryLastSeqName and whether it is set.
+func parseLastSeq(query url.Values) (uint32, bool, error) {
+	if !query.Has(queryLastSeqName) {
+		return 0, false, nil
+	}
+	seq, err := strconv.ParseUint(query.Get(queryLastSeqName), 10, 32)
+	if err != nil {
+		return 0, false, err
+	}
+	return uint32(seq), true, nil
+}
+
+// register adds a connected client to the registry and its channel.
+func (s *Server) register(c *client.Client) {
+	id := s.clients.Add(c)
+	s.channels.Join(c.Channel(), c)
+	s.logger.Info(
+		fmt.Sprintf("%s connected", c.Role()),
+		zap.Int("id", id),
+		zap.String("identity", c.Identity()),
+		zap.String("channel", c.Channel()),
+		zap.String("transport", c.Transport()),
+		zap.String("address", c.RemoteAddr()),
+	)
+	// Stop may have collected the clients before this one was added.
+	if s.isStopping() {
+		c.Shutdown(websocket.CloseGoingAway, "server shutdown")
+	}
+}
+
+// serve listens to a registered client until it disconnects.
+func (s *Server) serve(c *client.Client) {
+	c.Listen()
+	s.disconnect(c)
+	c.Close()
+}
+
+// track counts a connection goroutine unless the server is stopping, so Stop
+// never waits on a counter that is still growing.
+func (s *Server) track() bool {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	if s.stopping {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) isStopping() bool {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	return s.stopping
+}
+
+func (s *Server) clientOptions() client.Options {
+	return client.Options{
+		PingInterval: s.cfg.PingInterval,
+		PongTimeout:  s.cfg.PongTimeout,
+		IdleTimeout:  s.cfg.IdleTimeout,
+
+		QueueSize:    s.cfg.SendQueueSize,
+		SlowConsumer: s.slowConsumer,
+		WriteTimeout: s.cfg.WriteTimeout,
+
+		Hooks: s.metrics.clientHooks(),
+	}
+}
+
+func (s *Server) disconnect(c *client.Client) {
+	s.channels.Leave(c.Channel(), c)
+	if err := s.clients.Remove(c.ID()); err != nil {
+		s.logger.Error("remove client", zap.Int("id", c.ID()), zap.Error(err))
+	}
+}
+
+// onSignal relays state signals of a publisher to every subscriber of its
+// channel and counts acknowledgements of subscribers.
+func (s *Server) onSignal(from *client.Client, msg signals.Message) {
+	switch msg.Signal {
+	case signals.SignalAck:
+		if ch, ok := s.channels.Get(from.Channel()); ok {
+			ch.acknowledge(from, msg.Seq)
+		}
+	default:
+		s.publish(from.Channel(), msg.Signal, from.Identity())
+	}
+}
+
+// publish relays a state signal of the publisher to the subscribers of the
+// channel, see channels.Publish.
+func (s *Server) publish(channelName string, signal signals.Signal, publisher string) publishResult {
+	start := time.Now()
+	result := s.channels.Publish(channelName, signal, publisher)
+	s.metrics.observeFanout(start)
+	return result
+}
+
+// observe hands state signals and publisher presence changes of channels to
+// the integrations, see observer.
+func (s *Server) observe(channelName string, msg signals.Message) {
+	if s.bridge != nil && (msg.Signal == signals.SignalOn || msg.Signal == signals.SignalOff) {
+		s.bridge.forward(channelName, msg)
+	}
+	if s.webhooks != nil {
+		s.webhooks.Notify(channelName, msg)
 	}
 }
 
 func (s *Server) Run(ctx context.Context) error {
 	s.server.Handler = s.setupRoutes()
 
-	if err := s.server.ListenAndServe(); err != nil {
+	if s.jwt != nil {
+		go s.jwt.Watch(ctx)
+	}
+	if s.bridge != nil {
+		go s.bridge.run(ctx)
+	}
+	if s.webhooks != nil {
+		go s.webhooks.Run(ctx)
+	}
+	if s.history != nil {
+		go s.history.Run(ctx)
+	}
+
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
 		return err
 	}
+	tcp, udp, err := s.listenRaw()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	if tcp != nil {
+		go func() {
+			if err := s.serveTCP(tcp); err != nil {
+				s.logger.Error("serve tcp", zap.Error(err))
+			}
+		}()
+	}
+	if udp != nil {
+		go func() {
+			if err := s.serveUDP(udp); err != nil {
+				s.logger.Error("serve udp", zap.Error(err))
+			}
+		}()
+	}
+	s.stateMu.Lock()
+	s.listening = true
+	s.stateMu.Unlock()
+
+	if s.tls != nil {
+		go s.tls.Watch(ctx)
+		// Certificates come from TLSConfig, so the file names stay empty.
+		return s.server.ServeTLS(listener, "", "")
+	}
 
-	return nil
+	return s.server.Serve(listener)
 }
 
+// Stop closes the listener, asks every client to close with CloseGoingAway and
+// waits until they answer, the drain timeout passes or ctx is done. Clients
+// that are still connected afterwards are closed forcibly.
 func (s *Server) Stop(ctx context.Context) {
-	err := s.server.Shutdown(ctx)
-	if err != nil {
-		s.logger.Debug("shutdown error", zap.Error(err))
+	s.stateMu.Lock()
+	s.stopping = true
+	s.stateMu.Unlock()
+
+	// Shutdown waits for event streams, which end only after their clients drain.
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		if err := s.server.Shutdown(ctx); err != nil {
+			s.logger.Debug("shutdown error", zap.Error(err))
+		}
+	}()
+
+	s.raw.closeTCP()
+
+	for _, c := range s.clients.All() {
+		c.Shutdown(websocket.CloseGoingAway, "server shutdown")
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	timeout := s.cfg.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+	case <-timer.C:
+		s.terminate("drain timeout")
+	case <-ctx.Done():
+		s.terminate(ctx.Err().Error())
 	}
+	<-drained
+	<-shutdown
+	// UDP sessions are drained by now, the socket they share can go.
+	s.raw.closeUDP()
 
-	if s.client != nil {
-		s.client.Stop()
+	if s.history != nil {
+		if err := s.history.Close(); err != nil {
+			s.logger.Error("close history", zap.Error(err))
+		}
 	}
-	s.wg.Wait()
 
 	s.logger.Info("server stopped")
 }
+
+func (s *Server) terminate(reason string) {
+	clients := s.clients.All()
+	s.logger.Warn("closing remaining clients", zap.String("reason", reason), zap.Int("clients", len(clients)))
+	for _, c := range clients {
+		c.Terminate()
+	}
+}
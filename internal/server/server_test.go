@@ -0,0 +1,628 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/client"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/internal/history"
+	"github.com/serg-pe/signals/internal/webhook"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStop(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		answer bool
+	}{
+		{name: "clients answer close frame", answer: true},
+		{name: "clients stay silent", answer: false},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			drainTimeout := time.Millisecond * 500
+			s, err := New(config.ServerConfig{DrainTimeout: drainTimeout}, config.AuthConfig{}, zap.NewNop())
+			require.NoError(t, err)
+			srv := httptest.NewServer(s.setupRoutes())
+			defer srv.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/test", nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			closeErr := make(chan error, 1)
+			if tc.answer {
+				go func() {
+					for {
+						if _, _, err := conn.ReadMessage(); err != nil {
+							closeErr <- err
+							return
+						}
+					}
+				}()
+			}
+
+			require.Eventually(t, func() bool { return s.clients.Len() == 1 }, time.Second, time.Millisecond*10)
+
+			start := time.Now()
+			s.Stop(context.Background())
+			elapsed := time.Since(start)
+
+			assert.Equal(t, 0, s.clients.Len())
+			if !tc.answer {
+				assert.GreaterOrEqual(t, elapsed, drainTimeout)
+				return
+			}
+			assert.Less(t, elapsed, drainTimeout)
+			assert.True(t, websocket.IsCloseError(<-closeErr, websocket.CloseGoingAway))
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/connection/kitchen"
+	sub, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer sub.Close()
+	pub, _, err := websocket.DefaultDialer.Dial(url+"?is-initiator=true", nil)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	// Legacy protocol: presence, then connected publisher, then the state signal.
+	require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signals.SignalOn)}))
+	for i := 0; i < 3; i++ {
+		_, _, err := sub.ReadMessage()
+		require.NoError(t, err)
+	}
+
+	resp, err := http.Get(srv.URL + "/connection/kitchen")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	scrape := func() string {
+		resp, err := http.Get(srv.URL + "/metrics")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	expected := []string{
+		`signals_channel_publishers{channel="kitchen"} 1`,
+		`signals_channel_subscribers{channel="kitchen"} 1`,
+		`signals_received_total{signal="on"} 1`,
+		`signals_delivered_total{signal="on"} 1`,
+		`signals_upgrade_failures_total{reason="not_websocket"} 1`,
+		`signals_fanout_duration_seconds_count 1`,
+	}
+	// The delivered counter is bumped after the write returns, so it may lag behind the read.
+	assert.Eventually(t, func() bool {
+		body := scrape()
+		for _, line := range expected {
+			if !strings.Contains(body, line+"\n") {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+
+	check := func(path string, expectedCode int, expectedStatus string) healthReport {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		report := healthReport{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.Equal(t, expectedCode, resp.StatusCode, path)
+		assert.Equal(t, expectedStatus, report.Status, path)
+		return report
+	}
+
+	check("/healthz", http.StatusOK, healthStatusOK)
+	check("/readyz", http.StatusServiceUnavailable, healthStatusStarting)
+
+	s.stateMu.Lock()
+	s.listening = true
+	s.stateMu.Unlock()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/test?is-initiator=true", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return s.clients.Len() == 1 }, time.Second, time.Millisecond*10)
+
+	report := check("/readyz", http.StatusOK, healthStatusOK)
+	assert.Equal(t, 1, report.Channels)
+	assert.Equal(t, 1, report.Publishers)
+	assert.Equal(t, 0, report.Subscribers)
+	assert.NotEmpty(t, report.Version)
+
+	s.stateMu.Lock()
+	s.stopping = true
+	s.stateMu.Unlock()
+
+	check("/readyz", http.StatusServiceUnavailable, healthStatusDraining)
+	check("/healthz", http.StatusOK, healthStatusOK)
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+
+	events := make(chan webhook.Event, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := webhook.Event{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer hook.Close()
+
+	s, err := New(config.ServerConfig{Webhooks: config.WebhooksConfig{
+		Hooks: []config.WebhookConfig{{URL: hook.URL, Channels: []string{"kitchen"}}},
+	}}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.webhooks.Run(ctx)
+
+	pub, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/kitchen?is-initiator=true", nil)
+	require.NoError(t, err)
+	require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signals.SignalOn)}))
+	require.NoError(t, pub.Close())
+
+	for _, expected := range []signals.Signal{signals.SignalPublisherConnected, signals.SignalOn, signals.SignalPublisherDisconnected} {
+		select {
+		case event := <-events:
+			assert.Equal(t, "kitchen", event.Channel)
+			assert.Equal(t, expected, event.Event)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s not posted", expected)
+		}
+	}
+}
+
+// dial connects a websocket to the path of the test server.
+func dial(t *testing.T, srv *httptest.Server, path string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readSignal returns the signal of the next legacy message.
+func readSignal(t *testing.T, conn *websocket.Conn) signals.Signal {
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.NotEmpty(t, msg)
+	return signals.Signal(msg[0])
+}
+
+func TestFanOut(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	subs := []*websocket.Conn{dial(t, srv, "/connection/"), dial(t, srv, "/connection/")}
+	for _, sub := range subs {
+		assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+	}
+	pub := dial(t, srv, "/connection/?is-initiator=true")
+	for _, sub := range subs {
+		assert.Equal(t, signals.SignalPublisherConnected, readSignal(t, sub))
+	}
+
+	// Subscribers cannot publish.
+	require.NoError(t, subs[0].WriteMessage(websocket.BinaryMessage, []byte{byte(signals.SignalOff)}))
+	for _, signal := range []signals.Signal{signals.SignalOn, signals.SignalOff} {
+		require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signal)}))
+		for _, sub := range subs {
+			assert.Equal(t, signal, readSignal(t, sub))
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+
+	connected := func() int {
+		var clients []*client.Client
+		require.Eventually(t, func() bool {
+			clients = s.clients.All()
+			return len(clients) == 1
+		}, time.Second, time.Millisecond*10)
+		return clients[0].ID()
+	}
+
+	first := dial(t, srv, "/connection/kitchen")
+	firstID := connected()
+	require.NoError(t, first.Close())
+	require.Eventually(t, func() bool { return s.clients.Len() == 0 }, time.Second, time.Millisecond*10)
+
+	// The slot of the first client is taken again, its id is not.
+	second := dial(t, srv, "/connection/kitchen?is-initiator=true")
+	secondID := connected()
+	assert.Greater(t, secondID, firstID)
+	_, err = s.clients.Get(firstID)
+	assert.Error(t, err)
+	assert.Error(t, s.clients.Remove(firstID))
+
+	c, err := s.clients.Get(secondID)
+	require.NoError(t, err)
+	assert.Equal(t, client.RolePublisher, c.Role())
+
+	s.Stop(context.Background())
+	assert.Equal(t, 0, s.clients.Len())
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := second.ReadMessage(); err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+			break
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	kitchen := dial(t, srv, "/connection/kitchen")
+	hall := dial(t, srv, "/connection/hall")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, kitchen))
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, hall))
+
+	pub := dial(t, srv, "/connection/kitchen?is-initiator=true")
+	assert.Equal(t, signals.SignalPublisherConnected, readSignal(t, kitchen))
+	require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signals.SignalOn)}))
+	assert.Equal(t, signals.SignalOn, readSignal(t, kitchen))
+
+	// The hall has no publisher, so nothing of the kitchen arrives there.
+	hall.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	_, _, err = hall.ReadMessage()
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "unexpected message in hall: %v", err)
+
+	_, ok := s.channels.Get("hall")
+	assert.True(t, ok)
+	require.NoError(t, hall.Close())
+	require.Eventually(t, func() bool {
+		_, ok := s.channels.Get("hall")
+		return !ok
+	}, time.Second, time.Millisecond*10)
+	_, ok = s.channels.Get("kitchen")
+	assert.True(t, ok)
+}
+
+func TestPublisherPresence(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	sub := dial(t, srv, "/connection/kitchen")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+
+	first := dial(t, srv, "/connection/kitchen?is-initiator=true")
+	assert.Equal(t, signals.SignalPublisherConnected, readSignal(t, sub))
+	// Presence changes with the first and the last publisher only.
+	second := dial(t, srv, "/connection/kitchen?is-initiator=true")
+	require.NoError(t, second.WriteMessage(websocket.BinaryMessage, []byte{byte(signals.SignalOn)}))
+	assert.Equal(t, signals.SignalOn, readSignal(t, sub))
+
+	late := dial(t, srv, "/connection/kitchen")
+	assert.Equal(t, signals.SignalPublisherConnected, readSignal(t, late))
+
+	require.NoError(t, first.Close())
+	require.Eventually(t, func() bool {
+		ch, ok := s.channels.Get("kitchen")
+		return ok && len(ch.Publishers()) == 1
+	}, time.Second, time.Millisecond*10)
+	require.NoError(t, second.WriteMessage(websocket.BinaryMessage, []byte{byte(signals.SignalOff)}))
+	assert.Equal(t, signals.SignalOff, readSignal(t, sub))
+
+	// An abnormal close is a disconnect too.
+	require.NoError(t, second.UnderlyingConn().Close())
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+	assert.Equal(t, signals.SignalOff, readSignal(t, late))
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, late))
+}
+
+func TestHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pong timeout", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := New(config.ServerConfig{PingInterval: time.Millisecond * 50, PongTimeout: time.Millisecond * 100, DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+		require.NoError(t, err)
+		srv := httptest.NewServer(s.setupRoutes())
+		defer srv.Close()
+		defer s.Stop(context.Background())
+
+		// The subscriber reads and so answers pings, the publisher never reads.
+		sub := dial(t, srv, "/connection/kitchen")
+		assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+		dial(t, srv, "/connection/kitchen?is-initiator=true")
+		assert.Equal(t, signals.SignalPublisherConnected, readSignal(t, sub))
+
+		start := time.Now()
+		assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Eventually(t, func() bool { return s.clients.Len() == 1 }, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		t.Parallel()
+
+		idleTimeout := time.Millisecond * 200
+		s, err := New(config.ServerConfig{IdleTimeout: idleTimeout, DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+		require.NoError(t, err)
+		srv := httptest.NewServer(s.setupRoutes())
+		defer srv.Close()
+		defer s.Stop(context.Background())
+
+		start := time.Now()
+		sub := dial(t, srv, "/connection/kitchen")
+		assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, sub))
+		_, _, err = sub.ReadMessage()
+		assert.Error(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), idleTimeout)
+		assert.Eventually(t, func() bool { return s.clients.Len() == 0 }, time.Second, time.Millisecond*10)
+	})
+}
+
+func TestRetainLastSignal(t *testing.T) {
+	t.Parallel()
+
+	retainHall := false
+	s, err := New(config.ServerConfig{
+		RetainLastSignal: true,
+		Channels:         map[string]config.ChannelConfig{"hall": {RetainLastSignal: &retainHall}},
+		DrainTimeout:     time.Millisecond * 200,
+	}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	// publish sends the signal from a publisher that leaves right after, so the channel is dropped.
+	publish := func(channel string, signal signals.Signal) {
+		pub := dial(t, srv, "/connection/"+channel+"?is-initiator=true")
+		require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signal)}))
+		require.Eventually(t, func() bool {
+			ch, ok := s.channels.Get(channel)
+			if !ok {
+				return false
+			}
+			seq, _, _ := ch.state()
+			return seq > 0
+		}, time.Second, time.Millisecond*10)
+		require.NoError(t, pub.Close())
+		require.Eventually(t, func() bool {
+			_, ok := s.channels.Get(channel)
+			return !ok
+		}, time.Second, time.Millisecond*10)
+	}
+
+	publish("kitchen", signals.SignalOn)
+	publish("hall", signals.SignalOn)
+
+	kitchen := dial(t, srv, "/connection/kitchen")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, kitchen))
+	assert.Equal(t, signals.SignalOn, readSignal(t, kitchen))
+
+	hall := dial(t, srv, "/connection/hall")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, hall))
+	hall.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	_, _, err = hall.ReadMessage()
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "unexpected message in hall: %v", err)
+
+	// Sequence numbers continue after the retained signal.
+	require.NoError(t, kitchen.Close())
+	publish("kitchen", signals.SignalOff)
+	late := dial(t, srv, "/connection/kitchen")
+	assert.Equal(t, signals.SignalPublisherDisconnected, readSignal(t, late))
+	assert.Equal(t, signals.SignalOff, readSignal(t, late))
+	ch, ok := s.channels.Get("kitchen")
+	require.True(t, ok)
+	seq, last, hasLast := ch.state()
+	assert.Equal(t, uint32(2), seq)
+	assert.True(t, hasLast)
+	assert.Equal(t, signals.Message{Signal: signals.SignalOff, Seq: 2}, last)
+}
+
+func TestRetainedChannels(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.ServerConfig{RetainLastSignal: true}
+	cs := newChannels(cfg, zap.NewNop(), nil, nil)
+
+	// Without a history only the most recently dropped channels keep their retained signals.
+	for i := 0; i <= maxRetainedChannels; i++ {
+		cs.Publish(strconv.Itoa(i), signals.SignalOn, anonymousIdentity)
+	}
+	assert.Empty(t, cs.All())
+	assert.Len(t, cs.retained, maxRetainedChannels)
+	assert.NotContains(t, cs.retained, "0")
+	assert.Contains(t, cs.retained, strconv.Itoa(maxRetainedChannels))
+	assert.Equal(t, publishResult{Seq: 1}, cs.Publish("0", signals.SignalOff, anonymousIdentity))
+	// Dropping "0" again has forgotten "1" in turn.
+	assert.NotContains(t, cs.retained, "1")
+	assert.Equal(t, publishResult{Seq: 2}, cs.Publish("2", signals.SignalOff, anonymousIdentity))
+
+	// With a history nothing is kept in memory, the history has it all.
+	cfg.History.Dir = t.TempDir()
+	store, err := history.Open(history.Options{Dir: cfg.History.Dir}, zap.NewNop())
+	require.NoError(t, err)
+	defer store.Close()
+	cs = newChannels(cfg, zap.NewNop(), nil, store)
+
+	cs.Publish("kitchen", signals.SignalOn, anonymousIdentity)
+	assert.Empty(t, cs.retained)
+	assert.Equal(t, publishResult{Seq: 2}, cs.Publish("kitchen", signals.SignalOff, anonymousIdentity))
+	ch := cs.open("kitchen", inheritance{})
+	seq, last, hasLast := ch.state()
+	assert.Equal(t, uint32(2), seq)
+	assert.True(t, hasLast)
+	assert.Equal(t, signals.Message{Signal: signals.SignalOff, Seq: 2}, last)
+}
+
+func TestLeaveWithoutGlobalLock(t *testing.T) {
+	t.Parallel()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	observe := func(channel string, msg signals.Message) {
+		if channel == "kitchen" && msg.Signal == signals.SignalPublisherDisconnected {
+			close(entered)
+			<-release
+		}
+	}
+	cs := newChannels(config.ServerConfig{}, zap.NewNop(), observe, nil)
+
+	join := func(channel string, role client.Role) *client.Client {
+		conn, peer := net.Pipe()
+		t.Cleanup(func() { peer.Close() })
+		c := client.New(zap.NewNop(), client.NewTCP(conn, ""), client.Options{QueueSize: 4}, channel, role, nil)
+		t.Cleanup(func() {
+			c.Terminate()
+			c.Close()
+		})
+		cs.Join(channel, c)
+		return c
+	}
+
+	pub := join("kitchen", client.RolePublisher)
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		cs.Leave("kitchen", pub)
+	}()
+	<-entered
+
+	// Announcing the departure in the kitchen does not hold up the hall.
+	joined := make(chan struct{})
+	go func() {
+		defer close(joined)
+		join("hall", client.RoleSubscriber)
+	}()
+	select {
+	case <-joined:
+	case <-time.After(time.Second * 5):
+		t.Fatal("join blocked by a leave in another channel")
+	}
+
+	close(release)
+	<-left
+	_, ok := cs.Get("kitchen")
+	assert.False(t, ok)
+}
+
+func TestConnectionAuth(t *testing.T) {
+	t.Parallel()
+
+	authCfg := config.AuthConfig{
+		Enabled: true,
+		Tokens: []config.TokenConfig{
+			{Name: "cron", Token: "cron-secret", Role: "publish", Channels: []string{"kitchen"}},
+			{Name: "screen", Token: "screen-secret", Role: "subscribe"},
+		},
+	}
+	s, err := New(config.ServerConfig{DrainTimeout: time.Millisecond * 200}, authCfg, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewServer(s.setupRoutes())
+	defer srv.Close()
+	defer s.Stop(context.Background())
+
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		expected int
+	}{
+		{name: "no token", path: "/connection/kitchen", expected: http.StatusUnauthorized},
+		{name: "unknown token", path: "/connection/kitchen", token: "guess", expected: http.StatusUnauthorized},
+		{name: "wrong role", path: "/connection/kitchen?is-initiator=true", token: "screen-secret", expected: http.StatusForbidden},
+		{name: "wrong channel", path: "/connection/hall?is-initiator=true", token: "cron-secret", expected: http.StatusForbidden},
+		{name: "publisher", path: "/connection/kitchen?is-initiator=true", token: "cron-secret", expected: http.StatusSwitchingProtocols},
+		{name: "subscriber", path: "/connection/hall", token: "screen-secret", expected: http.StatusSwitchingProtocols},
+	}
+
+	for _, tc := range tests {
+		header := http.Header{}
+		if tc.token != "" {
+			header.Set("Authorization", "Bearer "+tc.token)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+tc.path, header)
+		require.NotNil(t, resp, tc.name)
+		assert.Equal(t, tc.expected, resp.StatusCode, tc.name)
+		if tc.expected == http.StatusSwitchingProtocols {
+			require.NoError(t, err, tc.name)
+			conn.Close()
+			continue
+		}
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake, tc.name)
+		// Rejected handshakes never reach the upgrade, so no client is registered for them.
+		assert.Equal(t, 0, s.clients.Len(), tc.name)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/serg-pe/signals/internal/client"
+	"go.uber.org/zap"
+)
+
+const headerLastEventID = "Last-Event-ID"
+
+// events streams the signals of a channel as server-sent events for clients
+// that cannot use websockets. A reconnecting EventSource sends Last-Event-ID,
+// the retained signal is replayed only if it is newer than that.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	channelName := r.PathValue(pathChannelName)
+
+	identity, status := s.authorize(w, r, channelName, false)
+	if status != http.StatusOK {
+		return
+	}
+
+	var (
+		lastEventID uint64
+		resume      bool
+	)
+	if raw := r.Header.Get(headerLastEventID); raw != "" {
+		var err error
+		lastEventID, err = strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			s.logger.Debug("parse last event id", zap.String("client", r.RemoteAddr), zap.String("value", raw))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resume = true
+	}
+
+	if !s.track() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer s.wg.Done()
+
+	transport, err := client.NewSSE(w, r)
+	if err != nil {
+		s.logger.Debug("start event stream", zap.String("client", r.RemoteAddr), zap.Error(err))
+		return
+	}
+
+	opts := s.clientOptions()
+	// Event stream clients cannot send anything, so they are never idle.
+	opts.IdleTimeout = 0
+
+	c := client.New(s.logger, transport, opts, channelName, client.RoleSubscriber, s.onSignal)
+	c.SetIdentity(identity.Name)
+	if resume {
+		c.SetResumeAfter(uint32(lastEventID))
+	}
+	s.register(c)
+
+	// The response is only valid until the handler returns, so it serves the client itself.
+	s.serve(c)
+}
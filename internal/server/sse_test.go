@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// readEvent returns the next event of the stream, skipping comments.
+func readEvent(t *testing.T, r *bufio.Reader) testEvent {
+	event := testEvent{}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && event.event != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(config.ServerConfig{RetainLastSignal: true, PingInterval: time.Millisecond * 50, PongTimeout: time.Second * 5, DrainTimeout: time.Millisecond * 200}, config.AuthConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(s.setupRoutes())
+	// Streams must outlive the timeouts of the http server.
+	srv.Config.ReadTimeout = time.Millisecond * 100
+	srv.Config.WriteTimeout = time.Millisecond * 100
+	srv.Start()
+	defer srv.Close()
+
+	pub, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/kitchen?is-initiator=true", nil)
+	require.NoError(t, err)
+	defer pub.Close()
+	publish := func(signal signals.Signal) {
+		require.NoError(t, pub.WriteMessage(websocket.BinaryMessage, []byte{byte(signal)}))
+	}
+
+	subscribe := func(lastEventID string) (*bufio.Reader, io.Closer) {
+		req, err := http.NewRequest("GET", srv.URL+"/channels/kitchen/events", nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set(headerLastEventID, lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), resp.Body
+	}
+
+	publish(signals.SignalOn)
+	require.Eventually(t, func() bool {
+		ch, ok := s.channels.Get("kitchen")
+		if !ok {
+			return false
+		}
+		seq, _, _ := ch.state()
+		return seq == 1
+	}, time.Second, time.Millisecond*10)
+
+	stream, body := subscribe("")
+	defer body.Close()
+	assert.Equal(t, testEvent{event: "publisher-connected", data: `{"signal":"publisher-connected"}`}, readEvent(t, stream))
+	assert.Equal(t, testEvent{id: "1", event: "on", data: `{"signal":"on","seq":1}`}, readEvent(t, stream))
+
+	time.Sleep(time.Millisecond * 300)
+	publish(signals.SignalOff)
+	assert.Equal(t, testEvent{id: "2", event: "off", data: `{"signal":"off","seq":2}`}, readEvent(t, stream))
+
+	upToDate, upToDateBody := subscribe("2")
+	defer upToDateBody.Close()
+	assert.Equal(t, "publisher-connected", readEvent(t, upToDate).event)
+
+	behind, behindBody := subscribe("1")
+	defer behindBody.Close()
+	assert.Equal(t, "publisher-connected", readEvent(t, behind).event)
+	assert.Equal(t, testEvent{id: "2", event: "off", data: `{"signal":"off","seq":2}`}, readEvent(t, behind))
+
+	publish(signals.SignalOn)
+	assert.Equal(t, "3", readEvent(t, upToDate).id)
+
+	s.Stop(context.Background())
+	_, err = io.ReadAll(stream)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.clients.Len())
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/serg-pe/signals/internal/config"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTLSReloadInterval = time.Second * 10
+)
+
+// tlsReloader serves the certificate and client CA pool loaded from files and
+// swaps them when the files change, so certificates rotate without a restart.
+type tlsReloader struct {
+	logger   *zap.Logger
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	current  atomic.Pointer[tls.Config]
+	modTimes map[string]time.Time
+}
+
+func newTLSReloader(cfg config.ServerConfig, logger *zap.Logger) (*tlsReloader, error) {
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return nil, errors.New("both tls_cert and tls_key are required")
+	}
+
+	interval := cfg.TLSReloadInterval
+	if interval <= 0 {
+		interval = defaultTLSReloadInterval
+	}
+
+	r := &tlsReloader{
+		logger:   logger,
+		certFile: cfg.TLSCert,
+		keyFile:  cfg.TLSKey,
+		caFile:   cfg.ClientCA,
+		interval: interval,
+		modTimes: make(map[string]time.Time),
+	}
+
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig is installed as http.Server.TLSConfig.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.current.Load().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// Watch reloads the files whenever they change until ctx is done. Files that
+// fail to load are logged and the previous certificate stays in use.
+func (r *tlsReloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				r.logger.Error("reload certificates", zap.Error(err))
+				continue
+			}
+			if reloaded {
+				r.logger.Info("certificates reloaded")
+			}
+		}
+	}
+}
+
+func (r *tlsReloader) reload() (bool, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+
+	modTimes := make(map[string]time.Time, len(files))
+	changed := r.current.Load() == nil
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[file] = info.ModTime()
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load key pair: %w", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return false, fmt.Errorf("read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("client ca %s has no certificates", r.caFile)
+		}
+		cfg.ClientCAs = pool
+		// Clients without a certificate may still authenticate with tokens.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	r.current.Store(cfg)
+	r.modTimes = modTimes
+	return true, nil
+}
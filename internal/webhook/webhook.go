@@ -0,0 +1,292 @@
+// Package webhook posts channel events to http endpoints with retries.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/serg-pe/signals/internal/auth"
+	"github.com/serg-pe/signals/internal/backoff"
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/serg-pe/signals/pkg/types/queue"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body keyed with the hook secret.
+	SignatureHeader = "X-Signals-Signature"
+	// EventHeader names the event, like the event field of the body.
+	EventHeader = "X-Signals-Event"
+
+	defaultTimeout       = time.Second * 5
+	defaultMaxAttempts   = 5
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = time.Minute
+	defaultQueueSize     = 256
+)
+
+// errPermanent marks responses that will not change on retry.
+var errPermanent = errors.New("permanent failure")
+
+// events are the signals hooks are notified about.
+var events = map[signals.Signal]struct{}{
+	signals.SignalOn:                    {},
+	signals.SignalOff:                   {},
+	signals.SignalPublisherConnected:    {},
+	signals.SignalPublisherDisconnected: {},
+}
+
+// Event is the JSON body posted to hooks.
+type Event struct {
+	Channel string         `json:"channel"`
+	Event   signals.Signal `json:"event"`
+	// Seq is the sequence number of state signals.
+	Seq  uint32    `json:"seq,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// hook delivers its events one at a time, so they arrive in order. Events wait
+// in pending while an earlier one is retried.
+type hook struct {
+	url      string
+	secret   string
+	channels []string
+	// events is nil when the hook wants every event.
+	events map[signals.Signal]struct{}
+
+	mu      *sync.Mutex
+	pending queue.Queue[Event]
+	wake    chan struct{}
+}
+
+// Dispatcher posts events to the hooks interested in them. Notify queues
+// events, Run delivers them until its context is done; events still queued
+// then are lost.
+type Dispatcher struct {
+	logger *zap.Logger
+	client *http.Client
+	hooks  []*hook
+
+	maxAttempts   int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	queueSize     int
+}
+
+func New(cfg config.WebhooksConfig, logger *zap.Logger) (*Dispatcher, error) {
+	d := &Dispatcher{
+		logger: logger,
+		client: &http.Client{Timeout: cfg.Timeout},
+
+		maxAttempts:   cfg.MaxAttempts,
+		retryDelay:    cfg.RetryDelay,
+		maxRetryDelay: cfg.MaxRetryDelay,
+		queueSize:     cfg.QueueSize,
+	}
+	if d.client.Timeout <= 0 {
+		d.client.Timeout = defaultTimeout
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = defaultMaxAttempts
+	}
+	if d.retryDelay <= 0 {
+		d.retryDelay = defaultRetryDelay
+	}
+	if d.maxRetryDelay <= 0 {
+		d.maxRetryDelay = defaultMaxRetryDelay
+	}
+	if d.queueSize <= 0 {
+		d.queueSize = defaultQueueSize
+	}
+
+	for _, hookCfg := range cfg.Hooks {
+		u, err := url.Parse(hookCfg.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook url %q", hookCfg.URL)
+		}
+
+		h := &hook{
+			url:      hookCfg.URL,
+			secret:   hookCfg.Secret,
+			channels: hookCfg.Channels,
+			mu:       &sync.Mutex{},
+			pending:  queue.New[Event](),
+			wake:     make(chan struct{}, 1),
+		}
+		for _, name := range hookCfg.Events {
+			var signal signals.Signal
+			if err := signal.UnmarshalText([]byte(name)); err != nil {
+				return nil, fmt.Errorf("webhook %q: %w", hookCfg.URL, err)
+			}
+			if _, ok := events[signal]; !ok {
+				return nil, fmt.Errorf("webhook %q: %q is not an event", hookCfg.URL, name)
+			}
+			if h.events == nil {
+				h.events = make(map[signals.Signal]struct{})
+			}
+			h.events[signal] = struct{}{}
+		}
+		d.hooks = append(d.hooks, h)
+	}
+
+	return d, nil
+}
+
+// Notify queues the message for every hook of the channel that wants it. It
+// never blocks, a full queue drops its oldest event.
+func (d *Dispatcher) Notify(channel string, msg signals.Message) {
+	if _, ok := events[msg.Signal]; !ok {
+		return
+	}
+	event := Event{Channel: channel, Event: msg.Signal, Seq: msg.Seq, Time: time.Now().UTC()}
+
+	for _, h := range d.hooks {
+		if !h.wants(event) {
+			continue
+		}
+
+		h.mu.Lock()
+		if h.pending.Len() >= d.queueSize {
+			dropped, _ := h.pending.Pop()
+			d.logger.Warn("webhook queue is full, event dropped", zap.String("url", h.url), zap.String("channel", dropped.Channel), zap.Stringer("event", dropped.Event))
+		}
+		h.pending.Push(event)
+		h.mu.Unlock()
+
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *hook) wants(event Event) bool {
+	if len(h.channels) > 0 && !auth.MatchChannel(h.channels, event.Channel) {
+		return false
+	}
+	if h.events == nil {
+		return true
+	}
+	_, ok := h.events[event.Event]
+	return ok
+}
+
+// Run delivers queued events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, h := range d.hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.serve(ctx, h)
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) serve(ctx context.Context, h *hook) {
+	for {
+		h.mu.Lock()
+		event, ok := h.pending.Pop()
+		h.mu.Unlock()
+
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-h.wake:
+				continue
+			}
+		}
+
+		d.deliver(ctx, h, event)
+	}
+}
+
+// deliver posts the event until the hook accepts it, the attempts run out or
+// ctx is done. Attempts are spaced with exponential backoff.
+func (d *Dispatcher) deliver(ctx context.Context, h *hook, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("encode webhook event", zap.Error(err))
+		return
+	}
+
+	for attempt := 0; attempt < d.maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff.Delay(attempt-1, d.retryDelay, d.maxRetryDelay)):
+			}
+		}
+
+		err = d.post(ctx, h, event, body)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		d.logger.Debug("deliver webhook", zap.String("url", h.url), zap.Int("attempt", attempt+1), zap.Error(err))
+		if errors.Is(err, errPermanent) {
+			break
+		}
+	}
+
+	d.logger.Warn(
+		"webhook event given up",
+		zap.String("url", h.url),
+		zap.String("channel", event.Channel),
+		zap.Stringer("event", event.Event),
+		zap.Error(err),
+	)
+}
+
+func (d *Dispatcher) post(ctx context.Context, h *hook, event Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Event.String())
+	if h.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body, so the connection is reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("%w: status %d", errPermanent, resp.StatusCode)
+	}
+}
+
+// Sign returns the value of SignatureHeader for the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
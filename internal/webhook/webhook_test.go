@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serg-pe/signals/internal/config"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type received struct {
+	event  Event
+	header string
+}
+
+// testHook answers the first failures requests with status and accepts the rest.
+func testHook(t *testing.T, status int, failures int32) (*httptest.Server, <-chan received, *atomic.Int32) {
+	requests := make(chan received, 10)
+	attempts := &atomic.Int32{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		event := Event{}
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, Sign("secret", body), r.Header.Get(SignatureHeader))
+
+		requests <- received{event: event, header: r.Header.Get(EventHeader)}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests, attempts
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		hook config.WebhookConfig
+		err  bool
+	}{
+		{name: "valid", hook: config.WebhookConfig{URL: "https://example.com/hook", Events: []string{"on", "publisher-connected"}}},
+		{name: "relative url", hook: config.WebhookConfig{URL: "/hook"}, err: true},
+		{name: "unknown event", hook: config.WebhookConfig{URL: "https://example.com/hook", Events: []string{"toggle"}}, err: true},
+		{name: "not an event", hook: config.WebhookConfig{URL: "https://example.com/hook", Events: []string{"ack"}}, err: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := New(config.WebhooksConfig{Hooks: []config.WebhookConfig{tc.hook}}, zap.NewNop())
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		status    int
+		failures  int32
+		delivered bool
+		attempts  int32
+	}{
+		{name: "accepted", failures: 0, delivered: true, attempts: 1},
+		{name: "retried", status: http.StatusServiceUnavailable, failures: 2, delivered: true, attempts: 3},
+		{name: "attempts run out", status: http.StatusInternalServerError, failures: 10, attempts: 3},
+		{name: "rejected", status: http.StatusBadRequest, failures: 10, attempts: 1},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv, requests, attempts := testHook(t, tc.status, tc.failures)
+
+			d, err := New(config.WebhooksConfig{
+				MaxAttempts: 3,
+				RetryDelay:  time.Millisecond,
+				Hooks:       []config.WebhookConfig{{URL: srv.URL, Secret: "secret"}},
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go d.Run(ctx)
+
+			d.Notify("kitchen", signals.Message{Signal: signals.SignalOn, Seq: 3})
+			// Signals that are not events are ignored.
+			d.Notify("kitchen", signals.Message{Signal: signals.SignalAck, Seq: 3})
+
+			if tc.delivered {
+				select {
+				case r := <-requests:
+					assert.Equal(t, "kitchen", r.event.Channel)
+					assert.Equal(t, signals.SignalOn, r.event.Event)
+					assert.Equal(t, uint32(3), r.event.Seq)
+					assert.Equal(t, "on", r.header)
+				case <-time.After(time.Second * 5):
+					t.Fatal("event not delivered")
+				}
+			}
+			require.Eventually(t, func() bool { return attempts.Load() == tc.attempts }, time.Second*5, time.Millisecond*10)
+			time.Sleep(time.Millisecond * 50)
+			assert.Equal(t, tc.attempts, attempts.Load())
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	d, err := New(config.WebhooksConfig{
+		QueueSize: 2,
+		Hooks: []config.WebhookConfig{
+			{URL: "http://127.0.0.1/all"},
+			{URL: "http://127.0.0.1/presence", Channels: []string{"floor-*"}, Events: []string{"publisher-connected", "publisher-disconnected"}},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	d.Notify("kitchen", signals.Message{Signal: signals.SignalPublisherConnected})
+	d.Notify("floor-1", signals.Message{Signal: signals.SignalOn, Seq: 1})
+	d.Notify("floor-1", signals.Message{Signal: signals.SignalPublisherConnected})
+	d.Notify("floor-1", signals.Message{Signal: signals.SignalOff, Seq: 2})
+
+	drain := func(h *hook) []Event {
+		var result []Event
+		for {
+			event, ok := h.pending.Pop()
+			if !ok {
+				return result
+			}
+			event.Time = time.Time{}
+			result = append(result, event)
+		}
+	}
+
+	// The queue keeps the newest events.
+	assert.Equal(t, []Event{
+		{Channel: "floor-1", Event: signals.SignalPublisherConnected},
+		{Channel: "floor-1", Event: signals.SignalOff, Seq: 2},
+	}, drain(d.hooks[0]))
+	assert.Equal(t, []Event{
+		{Channel: "floor-1", Event: signals.SignalPublisherConnected},
+	}, drain(d.hooks[1]))
+}
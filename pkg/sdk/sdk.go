@@ -0,0 +1,461 @@
+// Package sdk connects Go programs to the signals server as publishers or subscribers.
+package sdk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/internal/backoff"
+	"github.com/serg-pe/signals/pkg/signals"
+	"go.uber.org/zap"
+)
+
+const (
+	queryIsPublisherName = "is-initiator"
+	// queryLastSeqName asks the server to replay the state signals missed since a reconnect.
+	queryLastSeqName = "last-seq"
+
+	defaultPingInterval      = time.Second * 15
+	defaultReconnectMinDelay = time.Millisecond * 500
+	defaultReconnectMaxDelay = time.Second * 30
+	defaultHandshakeTimeout  = time.Second * 10
+	defaultWriteTimeout      = time.Second * 10
+	closeTimeout             = time.Second
+)
+
+var (
+	ErrNotConnected = errors.New("not connected")
+	ErrClosed       = errors.New("client closed")
+)
+
+type Options struct {
+	// Publisher connects as a publisher, otherwise as a subscriber.
+	Publisher bool
+	// Token is sent as a bearer Authorization header with every handshake.
+	Token string
+	// Header is sent with every handshake.
+	Header http.Header
+	// AutoAck answers every SignalOn and SignalOff with SignalAck.
+	AutoAck bool
+	// PingInterval is the period of SignalPing, a connection that stays silent
+	// for two periods is considered dead and reconnected.
+	PingInterval time.Duration
+	// ReconnectMinDelay and ReconnectMaxDelay bound the exponential backoff between reconnects.
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+	// WriteTimeout bounds every write, a connection that cannot take a message
+	// in time is dropped and reconnected.
+	WriteTimeout time.Duration
+	Logger       *zap.Logger
+}
+
+func (o *Options) setDefaults() {
+	if o.PingInterval <= 0 {
+		o.PingInterval = defaultPingInterval
+	}
+	if o.ReconnectMinDelay <= 0 {
+		o.ReconnectMinDelay = defaultReconnectMinDelay
+	}
+	if o.ReconnectMaxDelay < o.ReconnectMinDelay {
+		o.ReconnectMaxDelay = max(defaultReconnectMaxDelay, o.ReconnectMinDelay)
+	}
+	if o.WriteTimeout <= 0 {
+		o.WriteTimeout = defaultWriteTimeout
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+	if o.Token != "" {
+		o.Header = o.Header.Clone()
+		if o.Header == nil {
+			o.Header = http.Header{}
+		}
+		o.Header.Set("Authorization", "Bearer "+o.Token)
+	}
+}
+
+// Client keeps a connection to a channel open and reconnects it when it breaks.
+type Client struct {
+	url    string
+	opts   Options
+	logger *zap.Logger
+	dialer *websocket.Dialer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// mu guards the connection, it is never held while writing to it.
+	mu      *sync.Mutex
+	conn    *websocket.Conn
+	version uint8
+	isJSON  bool
+	// lastSeq is the sequence number of the last state signal received, sent with reconnects if hasSeq.
+	lastSeq uint32
+	hasSeq  bool
+	// writeMu serializes writes, a connection allows only one writer.
+	writeMu *sync.Mutex
+
+	subsMu      *sync.RWMutex
+	subscribers map[int]func(signals.Message)
+	nextSubID   int
+}
+
+// Dial connects to a channel url like ws://host:port/connection/kitchen. The
+// first connection attempt must succeed, later ones are retried until ctx is
+// done or Close is called.
+func Dial(ctx context.Context, rawURL string, opts Options) (*Client, error) {
+	opts.setDefaults()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Publisher {
+		query := u.Query()
+		query.Set(queryIsPublisherName, "true")
+		u.RawQuery = query.Encode()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Client{
+		url:    u.String(),
+		opts:   opts,
+		logger: opts.Logger.Named("signals"),
+		dialer: &websocket.Dialer{
+			HandshakeTimeout: defaultHandshakeTimeout,
+			Subprotocols:     append(signals.Subprotocols(), signals.SubprotocolJSON),
+		},
+
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+
+		mu:      &sync.Mutex{},
+		writeMu: &sync.Mutex{},
+
+		subsMu:      &sync.RWMutex{},
+		subscribers: make(map[int]func(signals.Message)),
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go c.run(conn)
+
+	return c, nil
+}
+
+// Publish sends a state signal to the subscribers of the channel.
+func (c *Client) Publish(signal signals.Signal) error {
+	return c.Send(signals.Message{Signal: signal})
+}
+
+// Ack confirms that the state signal with the sequence number has been handled.
+func (c *Client) Ack(seq uint32) error {
+	return c.Send(signals.Message{Signal: signals.SignalAck, Seq: seq})
+}
+
+// Send writes a message on the current connection. A write that fails or
+// takes longer than the write timeout drops the connection, it is reconnected.
+func (c *Client) Send(msg signals.Message) error {
+	c.mu.Lock()
+	conn, version, isJSON := c.conn, c.version, c.isJSON
+	c.mu.Unlock()
+
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	msgType := websocket.BinaryMessage
+	data, err := signals.Encode(version, msg)
+	if isJSON {
+		msgType = websocket.TextMessage
+		data, err = signals.EncodeJSON(msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout)); err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(msgType, data); err != nil {
+		// A failed write leaves a broken frame behind, the reader notices the closed connection and reconnects.
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Subscribe calls handler for every message received from the server until the
+// returned function is called. Handlers run on the reading goroutine and must
+// not block. A handler removed while a message is being delivered may still get that message.
+func (c *Client) Subscribe(handler func(msg signals.Message)) (unsubscribe func()) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	id := c.nextSubID
+	c.nextSubID++
+	c.subscribers[id] = handler
+
+	return func() {
+		c.subsMu.Lock()
+		defer c.subsMu.Unlock()
+		delete(c.subscribers, id)
+	}
+}
+
+// Messages delivers received messages to a channel with the given buffer.
+// Messages that do not fit into the buffer are dropped. The channel is closed
+// after the client stops.
+func (c *Client) Messages(buffer int) <-chan signals.Message {
+	messages := make(chan signals.Message, buffer)
+
+	mu := &sync.Mutex{}
+	closed := false
+	unsubscribe := c.Subscribe(func(msg signals.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case messages <- msg:
+		default:
+			c.logger.Debug("messages buffer is full, message dropped", zap.Stringer("signal", msg.Signal))
+		}
+	})
+
+	go func() {
+		<-c.done
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(messages)
+	}()
+
+	return messages
+}
+
+// Done is closed after the client stops reconnecting.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the connection and stops reconnecting. It does not wait for
+// writes in progress, they fail.
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	var err error
+	if conn != nil {
+		err = conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(closeTimeout),
+		)
+		conn.Close()
+	}
+	<-c.done
+
+	if errors.Is(err, websocket.ErrCloseSent) {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) connect() (*websocket.Conn, error) {
+	target, err := c.dialURL()
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := c.dialer.DialContext(c.ctx, target, c.opts.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	isJSON := conn.Subprotocol() == signals.SubprotocolJSON
+	version := signals.LatestVersion
+	if !isJSON {
+		version, err = signals.VersionFromSubprotocol(conn.Subprotocol())
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	conn.SetReadLimit(signals.MaxMessageLen)
+
+	c.mu.Lock()
+	c.conn = conn
+	c.version = version
+	c.isJSON = isJSON
+	c.mu.Unlock()
+
+	c.logger.Debug("connected", zap.String("url", c.url), zap.String("subprotocol", conn.Subprotocol()))
+	return conn, nil
+}
+
+// dialURL returns the channel url, with the sequence number of the last state
+// signal received once there is one, so the server replays what was missed.
+func (c *Client) dialURL() (string, error) {
+	c.mu.Lock()
+	seq, hasSeq := c.lastSeq, c.hasSeq
+	c.mu.Unlock()
+
+	if !hasSeq {
+		return c.url, nil
+	}
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set(queryLastSeqName, strconv.FormatUint(uint64(seq), 10))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.done)
+
+	for {
+		c.listen(conn)
+
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+
+		var ok bool
+		conn, ok = c.reconnect()
+		if !ok {
+			return
+		}
+	}
+}
+
+// listen reads messages until the connection breaks and pings the server meanwhile.
+func (c *Client) listen(conn *websocket.Conn) {
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go c.ping(stopPing)
+
+	stopWatch := context.AfterFunc(c.ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stopWatch()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.opts.PingInterval * 2))
+
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			if c.ctx.Err() == nil {
+				c.logger.Debug("connection lost", zap.Error(err))
+			}
+			return
+		}
+
+		var msg signals.Message
+		switch {
+		case c.isJSON && msgType == websocket.TextMessage:
+			msg, err = signals.DecodeJSON(data)
+		case !c.isJSON && msgType == websocket.BinaryMessage:
+			msg, err = signals.Decode(c.version, data)
+		default:
+			continue
+		}
+		if err != nil {
+			c.logger.Debug("decode message", zap.Error(err))
+			continue
+		}
+
+		c.handle(msg)
+	}
+}
+
+func (c *Client) handle(msg signals.Message) {
+	if msg.Signal == signals.SignalPong {
+		return
+	}
+
+	// A new incarnation of the channel numbers from the start again, so the last sequence number counts, not the highest.
+	if (msg.Signal == signals.SignalOn || msg.Signal == signals.SignalOff) && msg.Seq > 0 {
+		c.mu.Lock()
+		c.lastSeq, c.hasSeq = msg.Seq, true
+		c.mu.Unlock()
+	}
+
+	if c.opts.AutoAck && !c.opts.Publisher && (msg.Signal == signals.SignalOn || msg.Signal == signals.SignalOff) {
+		if err := c.Ack(msg.Seq); err != nil {
+			c.logger.Debug("ack signal", zap.Error(err))
+		}
+	}
+
+	c.subsMu.RLock()
+	handlers := make([]func(signals.Message), 0, len(c.subscribers))
+	for _, handler := range c.subscribers {
+		handlers = append(handlers, handler)
+	}
+	c.subsMu.RUnlock()
+
+	// Handlers run without the lock, so they may subscribe and unsubscribe.
+	for _, handler := range handlers {
+		handler(msg)
+	}
+}
+
+func (c *Client) ping(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := c.Send(signals.Message{Signal: signals.SignalPing}); err != nil {
+				c.logger.Debug("ping", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (c *Client) reconnect() (*websocket.Conn, bool) {
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(backoff.Delay(attempt, c.opts.ReconnectMinDelay, c.opts.ReconnectMaxDelay))
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return nil, false
+		case <-timer.C:
+		}
+
+		conn, err := c.connect()
+		if err == nil {
+			return conn, true
+		}
+		c.logger.Debug("reconnect", zap.Int("attempt", attempt), zap.Error(err))
+	}
+}
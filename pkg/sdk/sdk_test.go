@@ -0,0 +1,225 @@
+package sdk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/serg-pe/signals/pkg/signals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer answers every message with SignalOn carrying the received sequence
+// number and drops the connection after the first message when dropFirst is set.
+func testServer(t *testing.T, dropFirst bool) (string, <-chan *http.Request) {
+	upgrader := websocket.Upgrader{Subprotocols: signals.Subprotocols()}
+	requests := make(chan *http.Request, 10)
+	dropped := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		requests <- r
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg, err := signals.Decode(signals.Version1, data)
+			if err != nil || msg.Signal == signals.SignalPing {
+				continue
+			}
+			if dropFirst && !dropped {
+				dropped = true
+				return
+			}
+			reply, _ := signals.Encode(signals.Version1, signals.Message{Signal: signals.SignalOn, Seq: msg.Seq})
+			conn.WriteMessage(websocket.BinaryMessage, reply)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/connection/test", requests
+}
+
+func TestDialAndSubscribe(t *testing.T) {
+	t.Parallel()
+	u, requests := testServer(t, false)
+
+	c, err := Dial(context.Background(), u, Options{Publisher: true})
+	require.NoError(t, err)
+	defer c.Close()
+
+	r := <-requests
+	assert.Equal(t, "true", r.URL.Query().Get(queryIsPublisherName))
+
+	messages := c.Messages(1)
+	require.NoError(t, c.Ack(7))
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, signals.Message{Signal: signals.SignalOn, Seq: 7}, msg)
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+	u, requests := testServer(t, true)
+
+	c, err := Dial(context.Background(), u, Options{ReconnectMinDelay: time.Millisecond})
+	require.NoError(t, err)
+	<-requests
+
+	require.NoError(t, c.Publish(signals.SignalOff))
+
+	select {
+	case <-requests:
+	case <-time.After(time.Second):
+		t.Fatal("client did not reconnect")
+	}
+
+	assert.NoError(t, c.Close())
+	_, ok := <-c.Done()
+	assert.False(t, ok)
+	assert.ErrorIs(t, c.Publish(signals.SignalOn), ErrClosed)
+}
+
+func TestCloseWithContext(t *testing.T) {
+	t.Parallel()
+	u, _ := testServer(t, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := Dial(ctx, u, Options{})
+	require.NoError(t, err)
+
+	cancel()
+
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client did not stop")
+	}
+}
+
+func TestSubscribeInHandler(t *testing.T) {
+	t.Parallel()
+	u, _ := testServer(t, false)
+
+	c, err := Dial(context.Background(), u, Options{Publisher: true})
+	require.NoError(t, err)
+	defer c.Close()
+
+	first, second := make(chan signals.Message, 10), make(chan signals.Message, 10)
+	var unsubscribe func()
+	unsubscribe = c.Subscribe(func(msg signals.Message) {
+		first <- msg
+		unsubscribe()
+		c.Subscribe(func(msg signals.Message) { second <- msg })
+	})
+
+	receive := func(messages <-chan signals.Message) signals.Message {
+		select {
+		case msg := <-messages:
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("no message received")
+			return signals.Message{}
+		}
+	}
+
+	require.NoError(t, c.Ack(1))
+	assert.Equal(t, uint32(1), receive(first).Seq)
+	require.NoError(t, c.Ack(2))
+	assert.Equal(t, uint32(2), receive(second).Seq)
+	assert.Empty(t, first)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	// The server never reads, so writes block once the socket buffers are full.
+	upgrader := websocket.Upgrader{Subprotocols: []string{signals.SubprotocolJSON}}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/test", Options{
+		WriteTimeout:      time.Millisecond * 100,
+		ReconnectMinDelay: time.Hour,
+	})
+	require.NoError(t, err)
+
+	msg := signals.Message{Signal: signals.SignalOn, Payload: make([]byte, 1<<20)}
+	require.Eventually(t, func() bool { return c.Send(msg) != nil }, time.Second*10, time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("close blocked")
+	}
+}
+
+func TestReconnectResumes(t *testing.T) {
+	t.Parallel()
+
+	// The first connection delivers a state signal and breaks, later ones stay open.
+	upgrader := websocket.Upgrader{Subprotocols: signals.Subprotocols()}
+	requests := make(chan *http.Request, 10)
+	first := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		requests <- r
+
+		if first {
+			first = false
+			data, _ := signals.Encode(signals.Version1, signals.Message{Signal: signals.SignalOn, Seq: 5})
+			conn.WriteMessage(websocket.BinaryMessage, data)
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/connection/test", Options{ReconnectMinDelay: time.Millisecond})
+	require.NoError(t, err)
+	defer c.Close()
+
+	r := <-requests
+	assert.False(t, r.URL.Query().Has(queryLastSeqName))
+
+	select {
+	case r = <-requests:
+		assert.Equal(t, "5", r.URL.Query().Get(queryLastSeqName))
+	case <-time.After(time.Second * 5):
+		t.Fatal("client did not reconnect")
+	}
+}
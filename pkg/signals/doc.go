@@ -0,0 +1,42 @@
+// Package signals describes the messages exchanged between the server and its clients.
+//
+// # Handshake
+//
+// The protocol version is negotiated with the websocket subprotocol. A client
+// lists the versions it speaks in Sec-WebSocket-Protocol, e.g. "signals.v1",
+// and the server answers with the newest one it supports. A client that offers
+// no subprotocol speaks VersionLegacy.
+//
+// # VersionLegacy
+//
+// Every binary message is a single Signal byte followed by an optional payload.
+// There is no sequence number.
+//
+// # Version1
+//
+// Every binary message starts with an 8 byte header, all integers are big endian:
+//
+//	offset  size  field
+//	0       1     version, always 1
+//	1       1     signal
+//	2       4     sequence number
+//	6       2     payload length
+//	8       n     payload
+//
+// The server numbers SignalOn and SignalOff per channel, subscribers answer them
+// with SignalAck carrying the same sequence number. SignalPong repeats the
+// sequence number of SignalPing. Other signals use 0.
+//
+// # JSON
+//
+// A client offering SubprotocolJSON exchanges text messages holding one JSON
+// object each, e.g. {"signal":"on","seq":12}. Signals are named by
+// Signal.String, sequence numbers follow Version1. The statistic payload is
+// inlined as {"signal":"update-subscribers-statistic","subscribers":3,"acknowledged":1}.
+// JSON and binary clients may share a channel.
+//
+// # Payloads
+//
+// SignalUpdateSubscribersStatistic carries SubscribersStatistic, every other
+// signal has an empty payload.
+package signals
@@ -0,0 +1,111 @@
+package signals
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// SubprotocolJSON selects JSON text messages instead of binary ones.
+	SubprotocolJSON = "signals.json"
+)
+
+var (
+	ErrUnknownSignal = errors.New("unknown signal")
+	ErrMissingSignal = errors.New("missing signal")
+)
+
+var signalNames = map[Signal]string{
+	SignalOn:                         "on",
+	SignalOff:                        "off",
+	SignalPublisherDisconnected:      "publisher-disconnected",
+	SignalPublisherConnected:         "publisher-connected",
+	SignalUpdateSubscribersStatistic: "update-subscribers-statistic",
+	SignalPing:                       "ping",
+	SignalPong:                       "pong",
+	SignalAck:                        "ack",
+}
+
+func (s Signal) String() string {
+	if name, ok := signalNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal(%d)", byte(s))
+}
+
+func (s Signal) MarshalText() ([]byte, error) {
+	name, ok := signalNames[s]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownSignal, byte(s))
+	}
+	return []byte(name), nil
+}
+
+func (s *Signal) UnmarshalText(text []byte) error {
+	for signal, name := range signalNames {
+		if name == string(text) {
+			*s = signal
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownSignal, text)
+}
+
+// jsonMessage is the JSON form of Message, e.g. {"signal":"on","seq":12}.
+// The payload of SignalUpdateSubscribersStatistic is inlined as
+// "subscribers" and "acknowledged".
+type jsonMessage struct {
+	// Signal is a pointer, so a message without one is told apart from SignalOn.
+	Signal       *Signal `json:"signal"`
+	Seq          uint32  `json:"seq,omitempty"`
+	Subscribers  *uint32 `json:"subscribers,omitempty"`
+	Acknowledged *uint32 `json:"acknowledged,omitempty"`
+	Payload      []byte  `json:"payload,omitempty"`
+}
+
+// EncodeJSON serializes the message as a JSON object.
+func EncodeJSON(msg Message) ([]byte, error) {
+	raw := jsonMessage{Signal: &msg.Signal, Seq: msg.Seq}
+
+	if msg.Signal == SignalUpdateSubscribersStatistic {
+		stat := SubscribersStatistic{}
+		if err := stat.UnmarshalBinary(msg.Payload); err != nil {
+			return nil, err
+		}
+		raw.Subscribers = &stat.Subscribers
+		raw.Acknowledged = &stat.Acknowledged
+	} else {
+		raw.Payload = msg.Payload
+	}
+
+	return json.Marshal(raw)
+}
+
+// DecodeJSON parses a message serialized by EncodeJSON.
+func DecodeJSON(data []byte) (Message, error) {
+	if len(data) == 0 {
+		return Message{}, ErrEmptyMessage
+	}
+
+	raw := jsonMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Message{}, err
+	}
+	if raw.Signal == nil {
+		return Message{}, ErrMissingSignal
+	}
+
+	msg := Message{Signal: *raw.Signal, Seq: raw.Seq, Payload: raw.Payload}
+	if msg.Signal == SignalUpdateSubscribersStatistic {
+		stat := SubscribersStatistic{}
+		if raw.Subscribers != nil {
+			stat.Subscribers = *raw.Subscribers
+		}
+		if raw.Acknowledged != nil {
+			stat.Acknowledged = *raw.Acknowledged
+		}
+		msg.Payload, _ = stat.MarshalBinary()
+	}
+	return msg, nil
+}
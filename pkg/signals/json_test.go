@@ -0,0 +1,137 @@
+package signals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		msg         Message
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:        "signal with seq",
+			msg:         Message{Signal: SignalOn, Seq: 12},
+			expected:    `{"signal":"on","seq":12}`,
+			expectedErr: nil,
+		},
+		{
+			name:        "signal without seq",
+			msg:         Message{Signal: SignalPublisherConnected},
+			expected:    `{"signal":"publisher-connected"}`,
+			expectedErr: nil,
+		},
+		{
+			name:        "statistic",
+			msg:         SubscribersStatistic{Subscribers: 3, Acknowledged: 0}.Message(),
+			expected:    `{"signal":"update-subscribers-statistic","subscribers":3,"acknowledged":0}`,
+			expectedErr: nil,
+		},
+		{
+			name:        "unknown signal",
+			msg:         Message{Signal: Signal(200)},
+			expected:    "",
+			expectedErr: ErrUnknownSignal,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := EncodeJSON(tc.msg)
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tc.expected, string(actual))
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		data        string
+		expected    Message
+		expectedErr error
+	}{
+		{
+			name:        "signal with seq",
+			data:        `{"signal":"off","seq":12}`,
+			expected:    Message{Signal: SignalOff, Seq: 12},
+			expectedErr: nil,
+		},
+		{
+			name:        "ping",
+			data:        `{"signal":"ping"}`,
+			expected:    Message{Signal: SignalPing},
+			expectedErr: nil,
+		},
+		{
+			name:        "statistic",
+			data:        `{"signal":"update-subscribers-statistic","subscribers":258,"acknowledged":1}`,
+			expected:    Message{Signal: SignalUpdateSubscribersStatistic, Payload: []byte{0, 0, 1, 2, 0, 0, 0, 1}},
+			expectedErr: nil,
+		},
+		{
+			name:        "unknown signal",
+			data:        `{"signal":"blink"}`,
+			expected:    Message{},
+			expectedErr: ErrUnknownSignal,
+		},
+		{
+			name:        "empty",
+			data:        ``,
+			expected:    Message{},
+			expectedErr: ErrEmptyMessage,
+		},
+		{
+			name:        "no fields",
+			data:        `{}`,
+			expected:    Message{},
+			expectedErr: ErrMissingSignal,
+		},
+		{
+			name:        "null",
+			data:        `null`,
+			expected:    Message{},
+			expectedErr: ErrMissingSignal,
+		},
+		{
+			name:        "seq only",
+			data:        `{"seq":3}`,
+			expected:    Message{},
+			expectedErr: ErrMissingSignal,
+		},
+		{
+			name:        "null signal",
+			data:        `{"signal":null}`,
+			expected:    Message{},
+			expectedErr: ErrMissingSignal,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := DecodeJSON([]byte(tc.data))
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
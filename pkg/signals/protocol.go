@@ -0,0 +1,139 @@
+package signals
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	VersionLegacy uint8 = iota
+	Version1
+
+	LatestVersion = Version1
+)
+
+const (
+	subprotocolPrefix = "signals.v"
+
+	headerLenV1 = 8
+
+	// MaxPayloadLen is the largest payload a single message may carry.
+	MaxPayloadLen = math.MaxUint16
+	// MaxMessageLen is the largest encoded message of any supported version.
+	MaxMessageLen = headerLenV1 + MaxPayloadLen
+)
+
+var (
+	ErrEmptyMessage       = errors.New("empty message")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrVersionMismatch    = errors.New("protocol version mismatch")
+	ErrPayloadTooLarge    = errors.New("payload is too large")
+	ErrPayloadLength      = errors.New("payload length mismatch")
+)
+
+// Message is a single decoded protocol message.
+type Message struct {
+	Signal  Signal
+	Seq     uint32
+	Payload []byte
+}
+
+// Encode serializes the message using the given protocol version.
+func Encode(version uint8, msg Message) ([]byte, error) {
+	if len(msg.Payload) > MaxPayloadLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(msg.Payload))
+	}
+
+	switch version {
+	case VersionLegacy:
+		data := make([]byte, 1+len(msg.Payload))
+		data[0] = byte(msg.Signal)
+		copy(data[1:], msg.Payload)
+		return data, nil
+	case Version1:
+		data := make([]byte, headerLenV1+len(msg.Payload))
+		data[0] = Version1
+		data[1] = byte(msg.Signal)
+		binary.BigEndian.PutUint32(data[2:6], msg.Seq)
+		binary.BigEndian.PutUint16(data[6:8], uint16(len(msg.Payload)))
+		copy(data[headerLenV1:], msg.Payload)
+		return data, nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+}
+
+// Decode parses a message encoded with the given protocol version.
+func Decode(version uint8, data []byte) (Message, error) {
+	msg := Message{}
+
+	if len(data) == 0 {
+		return msg, ErrEmptyMessage
+	}
+
+	switch version {
+	case VersionLegacy:
+		msg.Signal = Signal(data[0])
+		if len(data) > 1 {
+			msg.Payload = data[1:]
+		}
+		return msg, nil
+	case Version1:
+		if len(data) < headerLenV1 {
+			return msg, fmt.Errorf("%w: want at least %d bytes, got %d", ErrShortMessage, headerLenV1, len(data))
+		}
+		if data[0] != Version1 {
+			return msg, fmt.Errorf("%w: want %d, got %d", ErrVersionMismatch, Version1, data[0])
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[6:8]))
+		if len(data)-headerLenV1 != payloadLen {
+			return msg, fmt.Errorf("%w: header says %d, got %d", ErrPayloadLength, payloadLen, len(data)-headerLenV1)
+		}
+
+		msg.Signal = Signal(data[1])
+		msg.Seq = binary.BigEndian.Uint32(data[2:6])
+		if payloadLen > 0 {
+			msg.Payload = data[headerLenV1:]
+		}
+		return msg, nil
+	default:
+		return msg, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+}
+
+// Subprotocol returns the websocket subprotocol announcing the version.
+func Subprotocol(version uint8) string {
+	return subprotocolPrefix + strconv.Itoa(int(version))
+}
+
+// Subprotocols lists the subprotocols of every supported version except
+// VersionLegacy, newest first, in the order a server should prefer them.
+func Subprotocols() []string {
+	result := make([]string, 0, LatestVersion)
+	for version := LatestVersion; version > VersionLegacy; version-- {
+		result = append(result, Subprotocol(version))
+	}
+	return result
+}
+
+// VersionFromSubprotocol returns the version negotiated with the subprotocol.
+// An empty subprotocol means VersionLegacy.
+func VersionFromSubprotocol(subprotocol string) (uint8, error) {
+	if subprotocol == "" {
+		return VersionLegacy, nil
+	}
+
+	raw, ok := strings.CutPrefix(subprotocol, subprotocolPrefix)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, subprotocol)
+	}
+	version, err := strconv.ParseUint(raw, 10, 8)
+	if err != nil || version == uint64(VersionLegacy) || version > uint64(LatestVersion) {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, subprotocol)
+	}
+	return uint8(version), nil
+}
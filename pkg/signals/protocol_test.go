@@ -0,0 +1,222 @@
+package signals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		version     uint8
+		msg         Message
+		expected    []byte
+		expectedErr error
+	}{
+		{
+			name:        "legacy signal",
+			version:     VersionLegacy,
+			msg:         Message{Signal: SignalOff, Seq: 10},
+			expected:    []byte{byte(SignalOff)},
+			expectedErr: nil,
+		},
+		{
+			name:        "legacy with payload",
+			version:     VersionLegacy,
+			msg:         Message{Signal: SignalUpdateSubscribersStatistic, Payload: []byte{1, 2}},
+			expected:    []byte{byte(SignalUpdateSubscribersStatistic), 1, 2},
+			expectedErr: nil,
+		},
+		{
+			name:        "v1 signal",
+			version:     Version1,
+			msg:         Message{Signal: SignalOn, Seq: 258},
+			expected:    []byte{Version1, byte(SignalOn), 0, 0, 1, 2, 0, 0},
+			expectedErr: nil,
+		},
+		{
+			name:        "v1 with payload",
+			version:     Version1,
+			msg:         Message{Signal: SignalUpdateSubscribersStatistic, Payload: []byte{1, 2, 3}},
+			expected:    []byte{Version1, byte(SignalUpdateSubscribersStatistic), 0, 0, 0, 0, 0, 3, 1, 2, 3},
+			expectedErr: nil,
+		},
+		{
+			name:        "unsupported version",
+			version:     LatestVersion + 1,
+			msg:         Message{Signal: SignalOn},
+			expected:    nil,
+			expectedErr: ErrUnsupportedVersion,
+		},
+		{
+			name:        "payload too large",
+			version:     Version1,
+			msg:         Message{Signal: SignalOn, Payload: make([]byte, MaxPayloadLen+1)},
+			expected:    nil,
+			expectedErr: ErrPayloadTooLarge,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := Encode(tc.version, tc.msg)
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		version     uint8
+		data        []byte
+		expected    Message
+		expectedErr error
+	}{
+		{
+			name:        "empty",
+			version:     Version1,
+			data:        []byte{},
+			expected:    Message{},
+			expectedErr: ErrEmptyMessage,
+		},
+		{
+			name:        "legacy signal",
+			version:     VersionLegacy,
+			data:        []byte{byte(SignalPing)},
+			expected:    Message{Signal: SignalPing},
+			expectedErr: nil,
+		},
+		{
+			name:        "legacy with payload",
+			version:     VersionLegacy,
+			data:        []byte{byte(SignalUpdateSubscribersStatistic), 1, 2},
+			expected:    Message{Signal: SignalUpdateSubscribersStatistic, Payload: []byte{1, 2}},
+			expectedErr: nil,
+		},
+		{
+			name:        "v1 signal",
+			version:     Version1,
+			data:        []byte{Version1, byte(SignalAck), 0, 0, 1, 2, 0, 0},
+			expected:    Message{Signal: SignalAck, Seq: 258},
+			expectedErr: nil,
+		},
+		{
+			name:        "v1 with payload",
+			version:     Version1,
+			data:        []byte{Version1, byte(SignalUpdateSubscribersStatistic), 0, 0, 0, 1, 0, 2, 9, 8},
+			expected:    Message{Signal: SignalUpdateSubscribersStatistic, Seq: 1, Payload: []byte{9, 8}},
+			expectedErr: nil,
+		},
+		{
+			name:        "v1 short header",
+			version:     Version1,
+			data:        []byte{Version1, byte(SignalOn), 0},
+			expected:    Message{},
+			expectedErr: ErrShortMessage,
+		},
+		{
+			name:        "v1 version mismatch",
+			version:     Version1,
+			data:        []byte{2, byte(SignalOn), 0, 0, 0, 0, 0, 0},
+			expected:    Message{},
+			expectedErr: ErrVersionMismatch,
+		},
+		{
+			name:        "v1 payload length mismatch",
+			version:     Version1,
+			data:        []byte{Version1, byte(SignalOn), 0, 0, 0, 0, 0, 2, 1},
+			expected:    Message{},
+			expectedErr: ErrPayloadLength,
+		},
+		{
+			name:        "unsupported version",
+			version:     LatestVersion + 1,
+			data:        []byte{byte(SignalOn)},
+			expected:    Message{},
+			expectedErr: ErrUnsupportedVersion,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := Decode(tc.version, tc.data)
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestVersionFromSubprotocol(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		subprotocol string
+		expected    uint8
+		expectedErr error
+	}{
+		{
+			name:        "no subprotocol",
+			subprotocol: "",
+			expected:    VersionLegacy,
+			expectedErr: nil,
+		},
+		{
+			name:        "v1",
+			subprotocol: Subprotocol(Version1),
+			expected:    Version1,
+			expectedErr: nil,
+		},
+		{
+			name:        "legacy is not announced",
+			subprotocol: Subprotocol(VersionLegacy),
+			expected:    0,
+			expectedErr: ErrUnsupportedVersion,
+		},
+		{
+			name:        "future version",
+			subprotocol: Subprotocol(LatestVersion + 1),
+			expected:    0,
+			expectedErr: ErrUnsupportedVersion,
+		},
+		{
+			name:        "foreign subprotocol",
+			subprotocol: "mqtt",
+			expected:    0,
+			expectedErr: ErrUnsupportedVersion,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := VersionFromSubprotocol(tc.subprotocol)
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
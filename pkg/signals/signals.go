@@ -1,5 +1,11 @@
 package signals
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
 type Signal byte
 
 const (
@@ -10,4 +16,45 @@ const (
 	SignalUpdateSubscribersStatistic
 	SignalPing
 	SignalPong
+	SignalAck
+)
+
+const (
+	subscribersStatisticLen = 4 + 4
+)
+
+var (
+	ErrShortMessage = errors.New("message is too short")
 )
+
+// SubscribersStatistic is the payload of SignalUpdateSubscribersStatistic:
+// Subscribers and Acknowledged as big endian uint32.
+type SubscribersStatistic struct {
+	// Subscribers is the number of subscribers connected to the channel.
+	Subscribers uint32
+	// Acknowledged is the number of subscribers that sent SignalAck after the last state signal.
+	Acknowledged uint32
+}
+
+func (s SubscribersStatistic) MarshalBinary() ([]byte, error) {
+	payload := make([]byte, subscribersStatisticLen)
+	binary.BigEndian.PutUint32(payload[0:4], s.Subscribers)
+	binary.BigEndian.PutUint32(payload[4:8], s.Acknowledged)
+	return payload, nil
+}
+
+func (s *SubscribersStatistic) UnmarshalBinary(payload []byte) error {
+	if len(payload) < subscribersStatisticLen {
+		return fmt.Errorf("%w: want %d bytes, got %d", ErrShortMessage, subscribersStatisticLen, len(payload))
+	}
+
+	s.Subscribers = binary.BigEndian.Uint32(payload[0:4])
+	s.Acknowledged = binary.BigEndian.Uint32(payload[4:8])
+	return nil
+}
+
+// Message wraps the statistic into a SignalUpdateSubscribersStatistic message.
+func (s SubscribersStatistic) Message() Message {
+	payload, _ := s.MarshalBinary()
+	return Message{Signal: SignalUpdateSubscribersStatistic, Payload: payload}
+}
@@ -0,0 +1,77 @@
+package signals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribersStatisticMarshal(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		stat     SubscribersStatistic
+		expected []byte
+	}{
+		{
+			name:     "empty",
+			stat:     SubscribersStatistic{},
+			expected: []byte{0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:     "filled",
+			stat:     SubscribersStatistic{Subscribers: 258, Acknowledged: 1},
+			expected: []byte{0, 0, 1, 2, 0, 0, 0, 1},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := tc.stat.MarshalBinary()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestSubscribersStatisticUnmarshal(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		msg         []byte
+		expected    SubscribersStatistic
+		expectedErr error
+	}{
+		{
+			name:        "valid",
+			msg:         []byte{0, 0, 1, 2, 0, 0, 0, 1},
+			expected:    SubscribersStatistic{Subscribers: 258, Acknowledged: 1},
+			expectedErr: nil,
+		},
+		{
+			name:        "short",
+			msg:         []byte{0, 0, 1},
+			expected:    SubscribersStatistic{},
+			expectedErr: ErrShortMessage,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := SubscribersStatistic{}
+			err := actual.UnmarshalBinary(tc.msg)
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
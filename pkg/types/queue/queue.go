@@ -6,19 +6,22 @@ type node[T any] struct {
 }
 
 type Queue[T any] struct {
-	head *node[T]
-	tail *node[T]
+	head   *node[T]
+	tail   *node[T]
+	length int
 }
 
 func New[T any]() Queue[T] {
 	return Queue[T]{
-		head: nil,
-		tail: nil,
+		head:   nil,
+		tail:   nil,
+		length: 0,
 	}
 }
 
 func (q *Queue[T]) Push(data T) {
 	node := &node[T]{data: data, next: nil}
+	q.length++
 
 	if q.head == nil {
 		q.head = node
@@ -39,5 +42,10 @@ func (q *Queue[T]) Pop() (T, bool) {
 
 	result = q.head.data
 	q.head = q.head.next
+	q.length--
 	return result, true
 }
+
+func (q *Queue[T]) Len() int {
+	return q.length
+}
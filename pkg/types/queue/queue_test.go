@@ -110,3 +110,55 @@ func TestPop(t *testing.T) {
 		})
 	}
 }
+
+func TestLen(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		pushSequence []int
+		popCount     int
+		expectedLen  int
+	}{
+		{
+			name:         "empty queue",
+			pushSequence: []int{},
+			popCount:     0,
+			expectedLen:  0,
+		},
+		{
+			name:         "pushed only",
+			pushSequence: []int{1, 2, 3},
+			popCount:     0,
+			expectedLen:  3,
+		},
+		{
+			name:         "pushed and popped",
+			pushSequence: []int{1, 2, 3},
+			popCount:     2,
+			expectedLen:  1,
+		},
+		{
+			name:         "popped more than pushed",
+			pushSequence: []int{1},
+			popCount:     3,
+			expectedLen:  0,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			q := New[int]()
+
+			for _, val := range tc.pushSequence {
+				q.Push(val)
+			}
+			for i := 0; i < tc.popCount; i++ {
+				q.Pop()
+			}
+
+			assert.Equal(t, tc.expectedLen, q.Len())
+		})
+	}
+}
@@ -125,9 +125,15 @@ func (s *ArrayStorage[T]) Update(id int, update func(entry T) T) error {
 	return nil
 }
 
+// Len returns the number of entries currently in use.
+func (s *ArrayStorage[T]) Len() int {
+	return s.length - s.unuseds
+}
+
 func (s *ArrayStorage[T]) addWithReallocation(entry T) int {
 	id := s.length
 	s.storage = append(s.storage, stored[T]{true, entry})
+	s.storage = s.storage[:cap(s.storage)]
 	s.capacity = cap(s.storage)
 	s.length++
 	return id
@@ -346,3 +346,61 @@ func TestUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestLen(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		add      int
+		removeAt []int
+		expected int
+	}{
+		{
+			name:     "empty",
+			add:      0,
+			removeAt: []int{},
+			expected: 0,
+		},
+		{
+			name:     "only added",
+			add:      5,
+			removeAt: []int{},
+			expected: 5,
+		},
+		{
+			name:     "removed from middle",
+			add:      5,
+			removeAt: []int{1, 3},
+			expected: 3,
+		},
+		{
+			name:     "removed last",
+			add:      5,
+			removeAt: []int{4, 3},
+			expected: 3,
+		},
+		{
+			name:     "removed all",
+			add:      3,
+			removeAt: []int{0, 1, 2},
+			expected: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s := New[int](2)
+
+			for i := 0; i < tc.add; i++ {
+				s.Add(i)
+			}
+			for _, id := range tc.removeAt {
+				assert.NoError(t, s.Remove(id))
+			}
+
+			assert.Equal(t, tc.expected, s.Len())
+		})
+	}
+}